@@ -6,9 +6,11 @@ require (
 	github.com/amarnathcjd/gogram v1.6.1-0.20250629075350-592c6e5e50c2
 	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
+	go.etcd.io/bbolt v1.4.0
 )
 
 require (
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/net v0.42.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 )
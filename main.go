@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"songBot/src"
 	"songBot/src/config"
+	"songBot/src/utils"
+	"sync"
+	"syscall"
 	"time"
 
 	tg "github.com/amarnathcjd/gogram/telegram"
@@ -14,96 +19,146 @@ import (
 
 var (
 	startTimeStamp = time.Now().Unix()
-	restartClient  = &http.Client{Timeout: 10 * time.Second}
+	shutdownOnce   sync.Once
 )
 
 func main() {
-	if config.Token == "" || config.ApiKey == "" || config.ApiUrl == "" {
-		log.Fatal("Missing environment variables. Please set TOKEN, API_KEY and API_URL")
+	if err := config.Err(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
+	cfg := config.Cfg
 
-	if err := os.Mkdir("downloads", os.ModePerm); err != nil && !os.IsExist(err) {
+	if err := os.Mkdir(cfg.DownloadPath, os.ModePerm); err != nil && !os.IsExist(err) {
 		log.Fatalf("Failed to create downloads directory: %v", err)
 	}
 
-	client, ok := buildAndStart(0, config.Token)
-	if !ok {
+	if err := utils.OpenDB(cfg.DatabasePath); err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	if err := utils.LoadStats(); err != nil {
+		log.Printf("[Stats] ❌ Failed to load stats: %v", err)
+	}
+	provider, err := utils.NewProvider(cfg.Provider)
+	if err != nil {
+		log.Fatalf("Failed to create music provider: %v", err)
+	}
+	utils.SetProvider(provider)
+
+	utils.SetTokenStore(utils.NewBoltTokenStore(), cfg.TokenTTL)
+	utils.StartTokenSweeper(time.Hour)
+	utils.StartStatsFlusher(time.Minute)
+
+	// Nothing runs yet, so every leftover partial download goes
+	if usage, err := utils.SweepDownloads(cfg.DownloadPath, cfg.DownloadsQuota, 0); err != nil {
+		log.Printf("[Janitor] ❌ Startup sweep failed: %v", err)
+	} else {
+		log.Printf("[Janitor] Downloads: %d files, %d bytes; evicted %d, removed %d temporary files",
+			usage.Files, usage.Bytes, usage.Evicted, usage.RemovedTemp)
+	}
+	utils.StartJanitor(cfg.JanitorInterval)
+	server := startHTTPServer(cfg.HTTPAddr)
+
+	pool := newBotPool(cfg.Tokens)
+	restarts := &restarter{pool: pool, server: server}
+	src.SetRestartFunc(restarts.restart)
+	if online := pool.start(); online == 0 {
 		log.Fatalf("[Client] Startup failed")
+	} else {
+		log.Printf("[Client] %d/%d clients online", online, len(cfg.Tokens))
 	}
 
-	go autoRestart(24 * time.Hour)
-	client.Idle()
+	go pool.supervise(30 * time.Second)
+	go restarts.watch(30 * time.Second)
+	go func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		<-ctx.Done()
+		stop() // a second signal kills the process
+		log.Printf("[Shutdown] Signal received, waiting up to %s for running downloads", cfg.ShutdownTimeout)
+		shutdown(pool, server)
+	}()
+	pool.idle()
 	log.Printf("[Client] Bot stopped.")
+	closeStores()
+
+	if restarts.execOnExit.Load() {
+		log.Printf("[Restart] Starting a new process")
+		if err := execSelf(); err != nil {
+			log.Fatalf("[Restart] ❌ Failed to start a new process: %v", err)
+		}
+	}
+}
+
+// closeStores saves the stats and closes the database
+func closeStores() {
+	if err := utils.FlushStats(); err != nil {
+		log.Printf("[Stats] ❌ Failed to save stats: %v", err)
+	}
+	_ = utils.CloseDB()
+}
+
+// shutdown stops taking downloads, lets the running ones finish within the shutdown timeout, removes
+// the partial files when they all did and then stops the HTTP server and the clients. Only the first
+// call does anything.
+func shutdown(pool *botPool, server *http.Server) {
+	shutdownOnce.Do(func() { drain(pool, server) })
 }
 
-func buildAndStart(index int, token string) (*tg.Client, bool) {
+func drain(pool *botPool, server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Cfg.ShutdownTimeout)
+	defer cancel()
+	if err := src.Shutdown(ctx); err != nil {
+		// The downloads still running may be writing their temporary files, the next start removes them
+		log.Printf("[Shutdown] ⚠️ Downloads did not finish in time, keeping temporary files: %v", err)
+	} else if removed, err := utils.RemoveTempFiles(config.Cfg.DownloadPath); err != nil {
+		log.Printf("[Shutdown] ❌ Failed to remove temporary files: %v", err)
+	} else if removed > 0 {
+		log.Printf("[Shutdown] Removed %d temporary files", removed)
+	}
+
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("[HTTP] ❌ Shutdown failed: %v", err)
+		}
+	}
+	pool.stop()
+}
+
+func buildAndStart(index int, token string) (*tg.Client, error) {
 	clientConfig := tg.ClientConfig{
-		AppID:        8,
-		AppHash:      "7245de8e747a0d6fbe11f7cc14fcc0bb",
-		FloodHandler: handleFlood,
+		AppID:        config.Cfg.AppID,
+		AppHash:      config.Cfg.AppHash,
+		FloodHandler: src.HandleFlood,
 		SessionName:  fmt.Sprintf("bot_%d", index),
 	}
 
 	client, err := tg.NewClient(clientConfig)
 	if err != nil {
 		log.Printf("[Client %d] ❌ Failed to create client: %v", index, err)
-		return nil, false
+		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
 	if _, err = client.Conn(); err != nil {
 		log.Printf("[Client %d] ❌ Connection error: %v", index, err)
-		return nil, false
+		return nil, fmt.Errorf("connection error: %w", err)
 	}
 
 	if err = client.LoginBot(token); err != nil {
 		log.Printf("[Client %d] ❌ Bot login failed: %v", index, err)
-		return nil, false
+		_ = client.Stop()
+		return nil, fmt.Errorf("bot login failed: %w", err)
 	}
 
 	me, err := client.GetMe()
 	if err != nil {
 		log.Printf("[Client %d] ❌ Failed to get bot info: %v", index, err)
-		return nil, false
+		_ = client.Stop()
+		return nil, fmt.Errorf("failed to get bot info: %w", err)
 	}
 
 	uptime := time.Since(time.Unix(startTimeStamp, 0)).String()
 	client.Logger.Info(fmt.Sprintf("✅ Client %d: @%s (Startup in %s)", index, me.Username, uptime))
 	src.InitFunc(client)
-	return client, true
-}
-
-func autoRestart(interval time.Duration) {
-	if config.CoolifyToken == "" {
-		log.Println("Coolify token not set; autoRestart disabled.")
-		return
-	}
-
-	go func() {
-		for {
-			time.Sleep(interval)
-			req, err := http.NewRequest("GET",
-				"https://app.ashok.sbs/api/v1/applications/lkkgog40occ0c8soo8gwcokk/restart", nil)
-			if err != nil {
-				log.Printf("[Restart] ❌ Request error: %v", err)
-				continue
-			}
-			req.Header.Set("Authorization", "Bearer "+config.CoolifyToken)
-
-			resp, err := restartClient.Do(req)
-			if err != nil {
-				log.Printf("[Restart] ❌ Request failed: %v", err)
-				continue
-			}
-			_ = resp.Body.Close()
-			log.Printf("[Restart] ✅ Status: %s", resp.Status)
-		}
-	}()
-}
-
-func handleFlood(err error) bool {
-	if wait := tg.GetFloodWait(err); wait > 0 {
-		time.Sleep(time.Duration(wait) * time.Second)
-		return true
-	}
-	return false
+	return client, nil
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"log"
+	"songBot/src"
+	"sync"
+	"time"
+
+	tg "github.com/amarnathcjd/gogram/telegram"
+)
+
+// maxClientFailures is how many failed health checks in a row trigger a client restart
+const maxClientFailures = 3
+
+// botSlot is one token of the pool and the client currently logged in with it
+type botSlot struct {
+	index      int
+	token      string
+	client     *tg.Client
+	status     src.BotStatus
+	failures   int
+	restarting bool
+}
+
+// botPool runs one client per bot token. All clients share the same handlers and caches,
+// and a failed client is restarted on its own without touching the others.
+type botPool struct {
+	mu    sync.Mutex
+	slots []*botSlot
+	done  chan struct{}
+}
+
+func newBotPool(tokens []string) *botPool {
+	pool := &botPool{done: make(chan struct{})}
+	for i, token := range tokens {
+		slot := &botSlot{index: i, token: token, status: src.BotStatus{Index: i}}
+		pool.slots = append(pool.slots, slot)
+		src.SetBotStatus(slot.status)
+	}
+	return pool
+}
+
+// start logs in every client concurrently and returns how many are online
+func (p *botPool) start() int {
+	var wg sync.WaitGroup
+	for _, slot := range p.slots {
+		wg.Add(1)
+		go func(slot *botSlot) {
+			defer wg.Done()
+			p.startSlot(slot)
+		}(slot)
+	}
+	wg.Wait()
+
+	online := 0
+	for _, slot := range p.slots {
+		if slot.client != nil {
+			online++
+		}
+	}
+	return online
+}
+
+// startSlot (re)creates the client of a slot and records the outcome
+func (p *botPool) startSlot(slot *botSlot) {
+	client, err := buildAndStart(slot.index, slot.token)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped() {
+		if client != nil {
+			_ = client.Stop()
+		}
+		return
+	}
+
+	slot.failures = 0
+	if err != nil {
+		slot.client = nil
+		slot.status.Connected = false
+		slot.status.LastError = err.Error()
+		src.SetBotStatus(slot.status)
+		return
+	}
+
+	slot.client = client
+	slot.status.Connected = true
+	slot.status.StartedAt = time.Now()
+	if me := client.Me(); me != nil {
+		slot.status.Username = me.Username
+	}
+	src.SetBotStatus(slot.status)
+}
+
+// supervise periodically checks every client and restarts the ones that stay disconnected
+func (p *botPool) supervise(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			for _, slot := range p.slots {
+				p.check(slot)
+			}
+		}
+	}
+}
+
+func (p *botPool) check(slot *botSlot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if slot.restarting {
+		return
+	}
+
+	if slot.client != nil && slot.client.IsConnected() {
+		slot.failures = 0
+		if !slot.status.Connected {
+			slot.status.Connected = true
+			src.SetBotStatus(slot.status)
+		}
+		return
+	}
+
+	slot.failures++
+	slot.status.Connected = false
+	src.SetBotStatus(slot.status)
+	if slot.failures < maxClientFailures {
+		return
+	}
+
+	log.Printf("[Client %d] ⚠️ Unhealthy for %d checks, restarting", slot.index, slot.failures)
+	slot.restarting = true
+	slot.status.Restarts++
+	old := slot.client
+	slot.client = nil
+
+	go func() {
+		if old != nil {
+			_ = old.Stop()
+		}
+		p.startSlot(slot)
+
+		p.mu.Lock()
+		slot.restarting = false
+		p.mu.Unlock()
+	}()
+}
+
+// stop disconnects every client and releases idle
+func (p *botPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped() {
+		return
+	}
+	close(p.done)
+	for _, slot := range p.slots {
+		if slot.client == nil {
+			continue
+		}
+		if err := slot.client.Stop(); err != nil {
+			log.Printf("[Client %d] ❌ Failed to disconnect: %v", slot.index, err)
+		}
+		slot.client = nil
+		slot.status.Connected = false
+		src.SetBotStatus(slot.status)
+	}
+}
+
+// stopped reports whether stop was called; p.mu must be held
+func (p *botPool) stopped() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// idle blocks until the pool is stopped
+func (p *botPool) idle() {
+	<-p.done
+}
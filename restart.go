@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"songBot/src"
+	"songBot/src/config"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	restartClient = &http.Client{Timeout: 10 * time.Second}
+
+	errRestartDisabled = errors.New("restarts are off (RESTART_MODE=off)")
+	errRestartPending  = errors.New("a restart is already in progress")
+)
+
+// restartCooldown is how long the automatic triggers stay quiet after a restart was requested, giving
+// the deployment platform time to act before it is asked again
+const restartCooldown = 10 * time.Minute
+
+// restarter restarts the bot the way config.Cfg.RestartMode says
+type restarter struct {
+	pool   *botPool
+	server *http.Server
+
+	busy atomic.Bool
+	// execOnExit tells main to start a new process once the bot stopped
+	execOnExit atomic.Bool
+}
+
+// restart restarts the bot for the given reason. In webhook mode it returns once the platform
+// accepted the request; in exec mode it returns right away and the downloads drain in the background.
+func (r *restarter) restart(reason string) error {
+	mode := config.Cfg.RestartMode
+	if mode == "off" {
+		return errRestartDisabled
+	}
+	if !r.busy.CompareAndSwap(false, true) {
+		return errRestartPending
+	}
+	log.Printf("[Restart] Restarting (%s) with mode %s", reason, mode)
+
+	if mode == "exec" {
+		r.execOnExit.Store(true)
+		go shutdown(r.pool, r.server)
+		return nil
+	}
+
+	defer r.busy.Store(false)
+	return callRestartWebhook()
+}
+
+// callRestartWebhook asks the deployment platform to restart the bot
+func callRestartWebhook() error {
+	cfg := config.Cfg
+	req, err := http.NewRequest(cfg.RestartWebhookMethod, cfg.RestartWebhookURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating request failed: %w", err)
+	}
+	for name, value := range cfg.RestartWebhookHeaders {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := restartClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	log.Printf("[Restart] ✅ Webhook status: %s", resp.Status)
+	return nil
+}
+
+// execSelf replaces the process with a new run of the same binary and arguments
+func execSelf() error {
+	path, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the executable: %w", err)
+	}
+	return syscall.Exec(path, os.Args, os.Environ())
+}
+
+// watch checks the restart triggers every interval: the restart schedule, the memory limit and how
+// long every client has been offline
+func (r *restarter) watch(interval time.Duration) {
+	cfg := config.Cfg
+	if cfg.RestartMode == "off" {
+		log.Println("[Restart] RESTART_MODE is off; automatic restarts disabled.")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	since := time.Now()
+	var offlineSince, requested time.Time
+	for range ticker.C {
+		if allClientsOffline() {
+			if offlineSince.IsZero() {
+				offlineSince = time.Now()
+			}
+		} else {
+			offlineSince = time.Time{}
+		}
+
+		if !requested.IsZero() && time.Since(requested) < restartCooldown {
+			continue
+		}
+
+		var reason string
+		switch {
+		case cfg.RestartInterval > 0 && time.Since(since) >= cfg.RestartInterval:
+			reason = "scheduled every " + cfg.RestartInterval.String()
+		case cfg.RestartMemoryLimit > 0 && memoryInUse() > cfg.RestartMemoryLimit:
+			reason = fmt.Sprintf("memory above %d MB", cfg.RestartMemoryLimit>>20)
+		case cfg.RestartOfflineAfter > 0 && !offlineSince.IsZero() && time.Since(offlineSince) >= cfg.RestartOfflineAfter:
+			reason = "every client offline for " + time.Since(offlineSince).Round(time.Second).String()
+		default:
+			continue
+		}
+
+		if err := r.restart(reason); err != nil {
+			log.Printf("[Restart] ❌ %v", err)
+			continue
+		}
+		// The platform may take a while to restart us; do not ask again until the cooldown is over,
+		// even when a trigger such as the memory limit still holds
+		since, offlineSince, requested = time.Now(), time.Time{}, time.Now()
+	}
+}
+
+// allClientsOffline reports whether no client of the pool is connected
+func allClientsOffline() bool {
+	for _, status := range src.BotStatuses() {
+		if status.Connected {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryInUse is the memory the Go runtime holds from the operating system
+func memoryInUse() int64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.Sys - m.HeapReleased)
+}
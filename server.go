@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"songBot/src"
+	"songBot/src/metrics"
+	"time"
+)
+
+// readyTimeout bounds the readiness checks of one /readyz request
+const readyTimeout = 5 * time.Second
+
+// startHTTPServer serves /metrics, /healthz and /readyz on addr in the background; it returns nil
+// when addr is empty
+func startHTTPServer(addr string) *http.Server {
+	if addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /readyz", readyzHandler)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("[HTTP] Listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[HTTP] ❌ Server failed: %v", err)
+		}
+	}()
+	return server
+}
+
+// healthzHandler answers as long as the process runs
+func healthzHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status": "ok",
+		"uptime": time.Since(time.Unix(startTimeStamp, 0)).Round(time.Second).String(),
+	})
+}
+
+// readyzHandler answers 200 when every readiness check passes and 503 otherwise
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	ready, checks := src.Readiness(ctx)
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("[HTTP] ❌ Failed to write response: %v", err)
+	}
+}
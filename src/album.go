@@ -0,0 +1,266 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"songBot/src/config"
+	"songBot/src/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// albumSize is the most media Telegram accepts in one group
+const albumSize = 10
+
+var (
+	errNotAudio    = errors.New("the downloaded file is not a playable audio")
+	errNothingSent = errors.New("no track could be sent")
+)
+
+// albumTrack is one track of a playlist sent as audio albums
+type albumTrack struct {
+	track  *utils.TrackInfo
+	source string // URL of the track in the playlist
+	keys   []string
+	media  telegram.InputMedia
+	path   string // downloaded file, empty when the track was cached
+	thumb  []byte
+	err    error
+}
+
+// parseAlbumFlag removes a "-a" (or "--album") flag from a query and reports whether it was there
+func parseAlbumFlag(query string) (string, bool) {
+	fields := strings.Fields(query)
+	for i, field := range fields {
+		if field == "-a" || field == "--album" {
+			return strings.Join(append(fields[:i:i], fields[i+1:]...), " "), true
+		}
+	}
+	return query, false
+}
+
+// queuePlaylistAlbum queues a playlist to be sent as audio albums, reporting its progress in msg
+func queuePlaylistAlbum(userID int64, query string, msg *telegram.NewMessage, tracks *utils.PlatformTracks, format utils.AudioFormat) {
+	utils.RecordRequest(utils.StatPlaylist, userID)
+	job := &utils.Job{
+		UserID:   userID,
+		Title:    fmt.Sprintf("Album: %s (%d tracks)", query, len(tracks.Results)),
+		Priority: utils.PriorityNormal,
+	}
+	job.OnQueued = func(position int) {
+		_, _ = msg.Edit(queuedText(position), telegram.SendOptions{ReplyMarkup: cancelMarkup(job.ID())})
+	}
+	job.Run = func(ctx context.Context) error {
+		return sendPlaylistAlbum(ctx, userID, msg, tracks, format, cancelMarkup(job.ID()))
+	}
+
+	runQueued(utils.StatPlaylist, job, func(text string) { _, _ = msg.Edit(text) })
+}
+
+// sendPlaylistAlbum sends the tracks as groups of up to ten audio messages, in playlist order.
+// Cached tracks are re-sent by file_id, the others are downloaded and uploaded.
+func sendPlaylistAlbum(ctx context.Context, userID int64, msg *telegram.NewMessage, tracks *utils.PlatformTracks, format utils.AudioFormat, cancel telegram.ReplyMarkup) error {
+	total := len(tracks.Results)
+	sent := 0
+	var failed []string
+
+	for start := 0; start < total; start += albumSize {
+		end := min(start+albumSize, total)
+		_, _ = msg.Edit(fmt.Sprintf("⏬ Preparing tracks %d-%d of %d...", start+1, end, total), telegram.SendOptions{ReplyMarkup: cancel})
+
+		// A stale cached file_id fails the whole group; sendAlbum drops the cached ones, so the retry
+		// uploads those tracks and reuses the uploads of the first attempt
+		group := make([]*albumTrack, end-start)
+		var err error
+		for attempt := 0; attempt < 2; attempt++ {
+			prepareAlbumTracks(ctx, msg.Client, tracks.Results[start:end], format, group)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			var ready []*albumTrack
+			var skipped []string
+			for _, t := range group {
+				if t.err != nil {
+					msg.Client.Logger.Warn("Album track failed:", t.err)
+					skipped = append(skipped, t.err.Error())
+					continue
+				}
+				ready = append(ready, t)
+			}
+
+			_, _ = msg.Edit(fmt.Sprintf("📤 Sending tracks %d-%d of %d...", start+1, end, total), telegram.SendOptions{ReplyMarkup: cancel})
+			var n int
+			n, err = sendAlbum(userID, msg, ready)
+			if err == nil {
+				sent += n
+				failed = append(failed, skipped...)
+				break
+			}
+			msg.Client.Logger.Warn("Failed to send album:", err)
+			if !utils.IsStaleFileError(err) || attempt == 1 {
+				failed = append(failed, skipped...)
+				for _, t := range ready {
+					failed = append(failed, fmt.Sprintf("%s - %s: %v", t.track.Name, t.track.Artist, err))
+				}
+				break
+			}
+			for i, t := range group {
+				if t.err == nil && t.path == "" {
+					group[i] = nil
+				}
+			}
+		}
+	}
+
+	summary := fmt.Sprintf("✅ Sent %d/%d tracks as audio.", sent, total)
+	if len(failed) > 0 {
+		summary += fmt.Sprintf("\n\n⚠️ %d tracks failed to send.", len(failed))
+	}
+	_, _ = msg.Edit(summary)
+	if sent == 0 && total > 0 {
+		return errNothingSent
+	}
+	return nil
+}
+
+// prepareAlbumTracks turns tracks into album media concurrently, filling the nil entries of group,
+// which holds the prepared tracks in the same order
+func prepareAlbumTracks(ctx context.Context, client *telegram.Client, tracks []utils.MusicTrack, format utils.AudioFormat, group []*albumTrack) {
+	sem := make(chan struct{}, config.Cfg.ZipConcurrency)
+	var wg sync.WaitGroup
+	for i, track := range tracks {
+		if group[i] != nil {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, track utils.MusicTrack) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			group[i] = prepareAlbumTrack(ctx, client, track, format)
+		}(i, track)
+	}
+	wg.Wait()
+}
+
+// prepareAlbumTrack returns the cached document of a track, or downloads and uploads it
+func prepareAlbumTrack(ctx context.Context, client *telegram.Client, track utils.MusicTrack, format utils.AudioFormat) *albumTrack {
+	urlKey := utils.FormatCacheKey(utils.URLCacheKey(botID(client), track.URL), format)
+	if entry, ok := utils.GetCachedFile(urlKey); ok {
+		if media, err := cachedInputMedia(entry.FileID); err == nil {
+			return &albumTrack{track: entry.Track(), source: track.URL, keys: []string{urlKey}, media: media}
+		}
+		utils.InvalidateCachedFile(entry.FileID)
+	}
+
+	info, err := utils.Provider().GetTrack(track.URL)
+	if err != nil {
+		return &albumTrack{track: &utils.TrackInfo{Name: track.Name, Artist: track.Artist}, err: fmt.Errorf("%s: %w", track.Name, err)}
+	}
+
+	t := &albumTrack{track: info, source: track.URL, keys: []string{urlKey, utils.FormatCacheKey(utils.TrackCacheKey(botID(client), info.TC), format)}}
+	if entry, ok := utils.GetCachedFile(t.keys[1]); ok {
+		if t.media, err = cachedInputMedia(entry.FileID); err == nil {
+			return t
+		}
+		utils.InvalidateCachedFile(entry.FileID)
+	}
+
+	dl, err := utils.NewDownload(*info)
+	if err != nil {
+		t.err = fmt.Errorf("%s: %w", info.Name, err)
+		return t
+	}
+	dl.Format = format
+
+	if t.path, t.thumb, err = dl.Process(ctx); err != nil {
+		t.err = fmt.Errorf("%s: %w", info.Name, err)
+		return t
+	}
+	if t.path, err = downloadTelegramLink(client, t.path); err != nil {
+		t.err = fmt.Errorf("%s: %w", info.Name, err)
+		return t
+	}
+	if !fileExists(t.path) {
+		t.err = fmt.Errorf("%s: %w", info.Name, errNotAudio)
+		return t
+	}
+
+	opts := prepareTrackMessageOptions(t.path, t.thumb, info, nil)
+	if opts.ForceDocument {
+		t.err = fmt.Errorf("%s: %w", info.Name, errNotAudio)
+		return t
+	}
+
+	started := time.Now()
+	if t.media, err = uploadTrackMedia(client, t.path, t.thumb, &opts); err != nil {
+		t.err = fmt.Errorf("%s: %w", info.Name, err)
+		return t
+	}
+	utils.ObserveDuration(utils.PhaseUpload, time.Since(started))
+	return t
+}
+
+// cachedInputMedia turns the file_id of an uploaded audio into a document usable in an album
+func cachedInputMedia(fileID string) (telegram.InputMedia, error) {
+	media, err := telegram.ResolveBotFileID(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := media.(*telegram.MessageMediaDocument); ok {
+		if doc, ok := m.Document.(*telegram.DocumentObj); ok {
+			return &telegram.InputMediaDocument{ID: &telegram.InputDocumentObj{
+				ID:            doc.ID,
+				AccessHash:    doc.AccessHash,
+				FileReference: doc.FileReference,
+			}}, nil
+		}
+	}
+	return nil, errors.New("file_id is not a document")
+}
+
+// sendAlbum sends the tracks as one media group below msg, caches the file_ids of what was sent
+// and records it in the history of the user. Flood waits are handled by the client's FloodHandler.
+func sendAlbum(userID int64, msg *telegram.NewMessage, tracks []*albumTrack) (int, error) {
+	if len(tracks) == 0 {
+		return 0, nil
+	}
+
+	album := make([]telegram.InputMedia, len(tracks))
+	captions := make([]string, len(tracks))
+	for i, t := range tracks {
+		album[i] = t.media
+		captions[i] = buildTrackCaption(t.track)
+	}
+
+	sent, err := msg.Client.SendAlbum(msg.ChatID(), album, &telegram.MediaOptions{Caption: captions})
+	if err != nil {
+		// A stale cached file_id fails the whole group, drop them so the next attempt uploads again
+		if utils.IsStaleFileError(err) {
+			for _, t := range tracks {
+				if t.path == "" {
+					if entry, ok := utils.GetCachedFile(t.keys...); ok {
+						utils.InvalidateCachedFile(entry.FileID)
+					}
+				}
+			}
+		}
+		return 0, err
+	}
+
+	for i, m := range sent {
+		if i >= len(tracks) || m == nil || m.File == nil {
+			continue
+		}
+		if tracks[i].path != "" {
+			utils.StoreCachedFile(utils.NewCachedFile(tracks[i].track, m.File.FileID, tracks[i].thumb), tracks[i].keys...)
+		}
+		utils.RecordDelivery(userID, botID(msg.Client), tracks[i].track, tracks[i].source, m.File.FileID)
+	}
+	return len(sent), nil
+}
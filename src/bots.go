@@ -0,0 +1,76 @@
+package src
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// BotStatus is the health of one client of the bot pool
+type BotStatus struct {
+	Index     int
+	Username  string
+	Connected bool
+	StartedAt time.Time
+	Restarts  int
+	LastError string
+}
+
+var (
+	botStatuses = make(map[int]BotStatus)
+	botStatusMu sync.RWMutex
+)
+
+// SetBotStatus records the current health of a client
+func SetBotStatus(status BotStatus) {
+	botStatusMu.Lock()
+	botStatuses[status.Index] = status
+	botStatusMu.Unlock()
+}
+
+// BotStatuses returns the health of every client ordered by index
+func BotStatuses() []BotStatus {
+	botStatusMu.RLock()
+	statuses := make([]BotStatus, 0, len(botStatuses))
+	for _, status := range botStatuses {
+		statuses = append(statuses, status)
+	}
+	botStatusMu.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Index < statuses[j].Index })
+	return statuses
+}
+
+// botsHandle shows the health of every client of the pool
+func botsHandle(m *telegram.NewMessage) error {
+	var sb strings.Builder
+	sb.WriteString("<b>🤖 Bot clients</b>\n")
+
+	for _, status := range BotStatuses() {
+		state := "🟢 online"
+		if !status.Connected {
+			state = "🔴 offline"
+		}
+
+		name := "-"
+		if status.Username != "" {
+			name = "@" + status.Username
+		}
+
+		sb.WriteString(fmt.Sprintf("\n<b>#%d</b> %s — %s\n", status.Index, name, state))
+		if !status.StartedAt.IsZero() {
+			sb.WriteString(fmt.Sprintf("Uptime: <code>%s</code>\n", time.Since(status.StartedAt).Round(time.Second)))
+		}
+		sb.WriteString(fmt.Sprintf("Restarts: <code>%d</code>\n", status.Restarts))
+		if status.LastError != "" {
+			sb.WriteString(fmt.Sprintf("Last error: <code>%s</code>\n", status.LastError))
+		}
+	}
+
+	_, err := m.Reply(sb.String())
+	return err
+}
@@ -1,15 +1,563 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 )
 
-var (
-	Token        = os.Getenv("TOKEN")
-	ApiKey       = os.Getenv("API_KEY")
-	ApiUrl       = os.Getenv("API_URL")
-	CoolifyToken = os.Getenv("COOLIFY_TOKEN")
-	DownloadPath = "downloads"
-)
+// Config holds every setting of the bot. Values come from the environment and, for keys not set
+// there, from the optional YAML or TOML file named by CONFIG_FILE.
+type Config struct {
+	Tokens       []string
+	AppID        int32
+	AppHash      string
+	ApiKey       string
+	ApiUrl       string
+	OwnerIDs     []int64
+	SudoIDs      []int64
+	DownloadPath string
+	DatabasePath string
+	TokenTTL     time.Duration
+
+	// DownloadsQuota caps the files kept in DownloadPath; the janitor evicts the least recently used
+	// ones every JanitorInterval
+	DownloadsQuota  int64
+	JanitorInterval time.Duration
+
+	// Provider is the music backend: "api" for the remote API, "fake" for the local FakeMusicDir
+	Provider     string
+	FakeMusicDir string
+
+	ApiTimeout      time.Duration
+	DownloadTimeout time.Duration
+	QueueWorkers    int
+	// ShutdownTimeout is how long running downloads may take to finish when the bot is stopped
+	ShutdownTimeout time.Duration
+	ZipConcurrency  int
+	// ZipPartSize caps each /playlist archive; bigger playlists are split into several parts
+	ZipPartSize int64
+	// AlbumMaxTracks caps the playlists sent as audio albums with /playlist -a
+	AlbumMaxTracks int
+
+	// HistoryEnabled lets users opt in to a /history of their downloads, keeping the latest HistoryLimit
+	HistoryEnabled bool
+	HistoryLimit   int
+	// FavoritesLimit caps the favorites of each user
+	FavoritesLimit int
+
+	// Number of results shown for /spotify and inline searches
+	SearchLimit       int
+	InlineSearchLimit int
+
+	// Rate limits per user; chats get ChatRateFactor times the user budget
+	SearchRate     Rate
+	InlineRate     Rate // inline queries, sent on every keystroke
+	TrackRate      Rate
+	PlaylistRate   Rate
+	ChatRateFactor int
+
+	// HTTPAddr is the listen address of the HTTP server for /metrics, /healthz and /readyz; empty disables it
+	HTTPAddr string
+
+	// RestartMode is how the bot restarts: "webhook" calls RestartWebhookURL so the deployment platform
+	// restarts it, "exec" drains the downloads and re-executes the binary, "off" never restarts
+	RestartMode           string
+	RestartWebhookURL     string
+	RestartWebhookMethod  string
+	RestartWebhookHeaders map[string]string
+	// Restart triggers besides /restart; zero disables one
+	RestartInterval     time.Duration
+	RestartMemoryLimit  int64
+	RestartOfflineAfter time.Duration
+}
+
+// Rate is a budget of Count events per Per; a zero Count disables the limit
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+func (r Rate) String() string {
+	if r.Count == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", r.Count, r.Per)
+}
+
+// Cfg is the effective configuration, loaded once at startup
+var Cfg, loadErr = Load()
+
+// Err returns every problem found while loading the configuration, or nil when it is valid
+func Err() error {
+	return loadErr
+}
+
+// Load reads the configuration and validates it. The returned Config is always usable (invalid
+// values are replaced by their defaults); the error lists every missing or invalid setting.
+func Load() (*Config, error) {
+	l := &loader{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("CONFIG_FILE: %w", err))
+		}
+		l.file = values
+	}
+
+	c := &Config{
+		Tokens:       l.list("TOKEN"),
+		AppID:        int32(l.int("APP_ID", 0)),
+		AppHash:      l.string("APP_HASH", ""),
+		ApiKey:       l.string("API_KEY", ""),
+		ApiUrl:       l.string("API_URL", ""),
+		Provider:     l.string("PROVIDER", "api"),
+		FakeMusicDir: l.string("FAKE_MUSIC_DIR", "fixtures"),
+		OwnerIDs:     l.ids("OWNER_IDS"),
+		SudoIDs:      l.ids("SUDO_IDS"),
+		DownloadPath: l.string("DOWNLOAD_PATH", "downloads"),
+		DatabasePath: l.string("DB_PATH", "songbot.db"),
+		TokenTTL:     l.duration("TOKEN_TTL", 7*24*time.Hour),
+
+		DownloadsQuota:  l.size("DOWNLOADS_QUOTA", 10<<30),
+		JanitorInterval: l.duration("JANITOR_INTERVAL", 10*time.Minute),
+
+		ApiTimeout:      l.duration("API_TIMEOUT", 60*time.Second),
+		DownloadTimeout: l.duration("DOWNLOAD_TIMEOUT", 4*time.Minute),
+		QueueWorkers:    l.int("QUEUE_WORKERS", 4),
+		ShutdownTimeout: l.duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		ZipConcurrency:  l.int("ZIP_CONCURRENCY", 10),
+		ZipPartSize:     l.size("ZIP_PART_SIZE", 1950<<20),
+		AlbumMaxTracks:  l.int("ALBUM_MAX_TRACKS", 50),
+
+		HistoryEnabled: l.bool("HISTORY_ENABLED", false),
+		HistoryLimit:   l.int("HISTORY_LIMIT", 100),
+		FavoritesLimit: l.int("FAVORITES_LIMIT", 200),
+
+		SearchLimit:       l.int("SEARCH_LIMIT", 5),
+		InlineSearchLimit: l.int("INLINE_SEARCH_LIMIT", 15),
+
+		SearchRate:     l.rate("RATE_LIMIT_SEARCH", Rate{Count: 20, Per: time.Minute}),
+		InlineRate:     l.rate("RATE_LIMIT_INLINE", Rate{Count: 60, Per: time.Minute}),
+		TrackRate:      l.rate("RATE_LIMIT_TRACKS", Rate{Count: 5, Per: time.Minute}),
+		PlaylistRate:   l.rate("RATE_LIMIT_PLAYLISTS", Rate{Count: 2, Per: 10 * time.Minute}),
+		ChatRateFactor: l.int("RATE_LIMIT_CHAT_FACTOR", 3),
+
+		HTTPAddr: l.string("HTTP_ADDR", ""),
+
+		RestartWebhookURL:     l.string("RESTART_WEBHOOK_URL", ""),
+		RestartWebhookMethod:  strings.ToUpper(l.string("RESTART_WEBHOOK_METHOD", "POST")),
+		RestartWebhookHeaders: l.headers("RESTART_WEBHOOK_HEADERS"),
+		RestartInterval:       l.optionalDuration("RESTART_INTERVAL", 24*time.Hour),
+		RestartMemoryLimit:    l.optionalSize("RESTART_MEMORY_LIMIT", 0),
+		RestartOfflineAfter:   l.optionalDuration("RESTART_OFFLINE_AFTER", 10*time.Minute),
+	}
+
+	// COOLIFY_TOKEN and COOLIFY_URL predate the restart webhook and still set it up
+	if token := l.string("COOLIFY_TOKEN", ""); token != "" && c.RestartWebhookURL == "" {
+		c.RestartWebhookURL = l.string("COOLIFY_URL", "")
+		c.RestartWebhookMethod = "GET"
+		c.RestartWebhookHeaders = map[string]string{"Authorization": "Bearer " + token}
+		if c.RestartWebhookURL == "" {
+			l.fail("COOLIFY_URL", "is required with COOLIFY_TOKEN; use RESTART_WEBHOOK_URL instead")
+		}
+	}
+
+	c.RestartMode = l.string("RESTART_MODE", "off")
+	if _, set := l.lookup("RESTART_MODE"); !set && c.RestartWebhookURL != "" {
+		c.RestartMode = "webhook"
+	}
+
+	c.validate(l)
+	return c, errors.Join(l.errs...)
+}
+
+// validate records the settings that parsed but are not usable
+func (c *Config) validate(l *loader) {
+	if len(c.Tokens) == 0 {
+		l.fail("TOKEN", "at least one bot token is required")
+	}
+	switch c.Provider {
+	case "api":
+		if c.ApiKey == "" {
+			l.fail("API_KEY", "is required")
+		}
+		if c.ApiUrl == "" {
+			l.fail("API_URL", "is required")
+		} else if !isAbsoluteURL(c.ApiUrl) {
+			l.fail("API_URL", "%q is not an absolute URL", c.ApiUrl)
+		}
+	case "fake":
+		if c.FakeMusicDir == "" {
+			l.fail("FAKE_MUSIC_DIR", "is required by the fake provider")
+		}
+	default:
+		l.fail("PROVIDER", "%q is not one of api, fake", c.Provider)
+	}
+	// There are no built-in defaults: every deployment brings its own app credentials and owners
+	if c.AppID <= 0 {
+		l.fail("APP_ID", "is required, get it from my.telegram.org")
+	}
+	if c.AppHash == "" {
+		l.fail("APP_HASH", "is required, get it from my.telegram.org")
+	}
+	if len(c.OwnerIDs) == 0 {
+		l.fail("OWNER_IDS", "at least one owner is required")
+	}
+	if c.ZipPartSize < 1<<20 {
+		l.fail("ZIP_PART_SIZE", "must be at least 1MB")
+	}
+	if c.HTTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.HTTPAddr); err != nil {
+			l.fail("HTTP_ADDR", "%q is not a host:port address", c.HTTPAddr)
+		}
+	}
+	switch c.RestartMode {
+	case "off", "exec":
+	case "webhook":
+		if !isAbsoluteURL(c.RestartWebhookURL) {
+			l.fail("RESTART_WEBHOOK_URL", "%q is not an absolute URL", c.RestartWebhookURL)
+		}
+		if strings.Trim(c.RestartWebhookMethod, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			l.fail("RESTART_WEBHOOK_METHOD", "%q is not an HTTP method", c.RestartWebhookMethod)
+		}
+	default:
+		l.fail("RESTART_MODE", "%q is not one of off, webhook, exec", c.RestartMode)
+	}
+
+	for _, n := range []struct {
+		key   string
+		value int
+	}{
+		{"QUEUE_WORKERS", c.QueueWorkers},
+		{"ZIP_CONCURRENCY", c.ZipConcurrency},
+		{"ALBUM_MAX_TRACKS", c.AlbumMaxTracks},
+		{"HISTORY_LIMIT", c.HistoryLimit},
+		{"FAVORITES_LIMIT", c.FavoritesLimit},
+		{"SEARCH_LIMIT", c.SearchLimit},
+		{"INLINE_SEARCH_LIMIT", c.InlineSearchLimit},
+		{"RATE_LIMIT_CHAT_FACTOR", c.ChatRateFactor},
+	} {
+		if n.value <= 0 {
+			l.fail(n.key, "must be positive, got %d", n.value)
+		}
+	}
+}
+
+func isAbsoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// IsOwner reports whether the user is one of the bot owners
+func (c *Config) IsOwner(id int64) bool {
+	return containsID(c.OwnerIDs, id)
+}
+
+// IsSudo reports whether the user is an owner or a sudo user
+func (c *Config) IsSudo(id int64) bool {
+	return c.IsOwner(id) || containsID(c.SudoIDs, id)
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe lists the effective settings as name/value pairs, with secrets redacted
+func (c *Config) Describe() [][2]string {
+	return [][2]string{
+		{"TOKEN", fmt.Sprintf("%d token(s)", len(c.Tokens))},
+		{"APP_ID", strconv.Itoa(int(c.AppID))},
+		{"APP_HASH", redact(c.AppHash)},
+		{"API_KEY", redact(c.ApiKey)},
+		{"API_URL", c.ApiUrl},
+		{"PROVIDER", c.Provider},
+		{"FAKE_MUSIC_DIR", c.FakeMusicDir},
+		{"OWNER_IDS", formatIDs(c.OwnerIDs)},
+		{"SUDO_IDS", formatIDs(c.SudoIDs)},
+		{"DOWNLOAD_PATH", c.DownloadPath},
+		{"DB_PATH", c.DatabasePath},
+		{"TOKEN_TTL", c.TokenTTL.String()},
+		{"DOWNLOADS_QUOTA", formatSize(c.DownloadsQuota)},
+		{"JANITOR_INTERVAL", c.JanitorInterval.String()},
+		{"API_TIMEOUT", c.ApiTimeout.String()},
+		{"DOWNLOAD_TIMEOUT", c.DownloadTimeout.String()},
+		{"QUEUE_WORKERS", strconv.Itoa(c.QueueWorkers)},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout.String()},
+		{"ZIP_CONCURRENCY", strconv.Itoa(c.ZipConcurrency)},
+		{"ZIP_PART_SIZE", formatSize(c.ZipPartSize)},
+		{"ALBUM_MAX_TRACKS", strconv.Itoa(c.AlbumMaxTracks)},
+		{"HISTORY_ENABLED", strconv.FormatBool(c.HistoryEnabled)},
+		{"HISTORY_LIMIT", strconv.Itoa(c.HistoryLimit)},
+		{"FAVORITES_LIMIT", strconv.Itoa(c.FavoritesLimit)},
+		{"SEARCH_LIMIT", strconv.Itoa(c.SearchLimit)},
+		{"INLINE_SEARCH_LIMIT", strconv.Itoa(c.InlineSearchLimit)},
+		{"RATE_LIMIT_SEARCH", c.SearchRate.String()},
+		{"RATE_LIMIT_INLINE", c.InlineRate.String()},
+		{"RATE_LIMIT_TRACKS", c.TrackRate.String()},
+		{"RATE_LIMIT_PLAYLISTS", c.PlaylistRate.String()},
+		{"RATE_LIMIT_CHAT_FACTOR", strconv.Itoa(c.ChatRateFactor)},
+		{"HTTP_ADDR", c.HTTPAddr},
+		{"RESTART_MODE", c.RestartMode},
+		{"RESTART_WEBHOOK_URL", redactURL(c.RestartWebhookURL)},
+		{"RESTART_WEBHOOK_METHOD", c.RestartWebhookMethod},
+		{"RESTART_WEBHOOK_HEADERS", redactHeaders(c.RestartWebhookHeaders)},
+		{"RESTART_INTERVAL", formatOptional(c.RestartInterval > 0, c.RestartInterval.String())},
+		{"RESTART_MEMORY_LIMIT", formatOptional(c.RestartMemoryLimit > 0, formatSize(c.RestartMemoryLimit))},
+		{"RESTART_OFFLINE_AFTER", formatOptional(c.RestartOfflineAfter > 0, c.RestartOfflineAfter.String())},
+	}
+}
+
+// redact hides a secret, keeping only enough of it to tell values apart
+func redact(secret string) string {
+	switch {
+	case secret == "":
+		return "(not set)"
+	case len(secret) <= 8:
+		return "****"
+	default:
+		return secret[:4] + "****"
+	}
+}
+
+// redactURL keeps the host of a URL and hides its path, which often embeds an application ID
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return redact(raw)
+	}
+	return u.Scheme + "://" + u.Host + "/****"
+}
+
+// redactHeaders lists the header names, hiding their values
+func redactHeaders(headers map[string]string) string {
+	if len(headers) == 0 {
+		return "-"
+	}
+	parts := make([]string, 0, len(headers))
+	for name, value := range headers {
+		parts = append(parts, name+": "+redact(value))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// formatOptional writes text for a setting that is on, and "off" otherwise
+func formatOptional(on bool, text string) string {
+	if !on {
+		return "off"
+	}
+	return text
+}
+
+// formatSize writes a size in the largest unit that divides it, e.g. "1950MB"
+func formatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n >= u.size && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.suffix + "B"
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func formatIDs(ids []int64) string {
+	if len(ids) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// loader reads typed settings, collecting an error for every invalid value instead of stopping at the first
+type loader struct {
+	file map[string]string
+	errs []error
+}
+
+func (l *loader) fail(key, format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+// lookup returns the raw value of key, preferring the environment over the config file
+func (l *loader) lookup(key string) (string, bool) {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v, true
+	}
+	v, ok := l.file[key]
+	return v, ok && v != ""
+}
+
+func (l *loader) string(key, def string) string {
+	if v, ok := l.lookup(key); ok {
+		return v
+	}
+	return def
+}
+
+// list splits a comma-separated value, dropping empty items
+func (l *loader) list(key string) []string {
+	raw, _ := l.lookup(key)
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (l *loader) ids(key string) []int64 {
+	var ids []int64
+	for _, item := range l.list(key) {
+		id, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			l.fail(key, "%q is not a user ID", item)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (l *loader) int(key string, def int) int {
+	raw, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		l.fail(key, "%q is not a number", raw)
+		return def
+	}
+	return n
+}
+
+// bool parses true/false (also 1/0, yes/no, on/off)
+func (l *loader) bool(key string, def bool) bool {
+	raw, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+	switch strings.ToLower(raw) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	l.fail(key, "%q is not true or false", raw)
+	return def
+}
+
+// duration parses a Go duration (e.g. "72h")
+func (l *loader) duration(key string, def time.Duration) time.Duration {
+	raw, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		l.fail(key, "%q is not a positive duration", raw)
+		return def
+	}
+	return d
+}
+
+// optionalDuration is like duration, but "0" or "off" disables the setting
+func (l *loader) optionalDuration(key string, def time.Duration) time.Duration {
+	if raw, ok := l.lookup(key); ok && (raw == "0" || strings.EqualFold(raw, "off")) {
+		return 0
+	}
+	return l.duration(key, def)
+}
+
+// headers parses comma-separated "Name: value" pairs
+func (l *loader) headers(key string) map[string]string {
+	items := l.list(key)
+	if len(items) == 0 {
+		return nil
+	}
+
+	headers := make(map[string]string, len(items))
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, ":")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			l.fail(key, "%q is not a \"Name: value\" header", item)
+			continue
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers
+}
+
+// sizeUnits are the binary size suffixes accepted by loader.size, largest first
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// size parses a byte count with an optional K(B), M(B) or G(B) suffix (e.g. "1950MB")
+func (l *loader) size(key string, def int64) int64 {
+	raw, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+
+	number, unit := strings.TrimSuffix(strings.ToUpper(raw), "B"), int64(1)
+	for _, u := range sizeUnits {
+		if trimmed, found := strings.CutSuffix(number, u.suffix); found {
+			number, unit = strings.TrimSpace(trimmed), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		l.fail(key, "%q is not a size, e.g. 1950MB", raw)
+		return def
+	}
+	return n * unit
+}
+
+// optionalSize is like size, but "0" or "off" disables the setting
+func (l *loader) optionalSize(key string, def int64) int64 {
+	if raw, ok := l.lookup(key); ok && (raw == "0" || strings.EqualFold(raw, "off")) {
+		return 0
+	}
+	return l.size(key, def)
+}
+
+// rate parses a rate written as "count/duration" (e.g. "5/1m"); a count of 0 disables the limit
+func (l *loader) rate(key string, def Rate) Rate {
+	raw, ok := l.lookup(key)
+	if !ok {
+		return def
+	}
+
+	count, per, _ := strings.Cut(raw, "/")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	d, perErr := time.ParseDuration(strings.TrimSpace(per))
+	if err != nil || n < 0 || perErr != nil || d <= 0 {
+		l.fail(key, "%q must look like count/duration, e.g. 5/1m", raw)
+		return def
+	}
+	return Rate{Count: n, Per: d}
+}
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets every setting for the test, so only what it sets is loaded
+func clearEnv(t *testing.T) {
+	t.Helper()
+	keys := []string{"CONFIG_FILE", "COOLIFY_TOKEN", "COOLIFY_URL"}
+	for _, kv := range (&Config{}).Describe() {
+		keys = append(keys, kv[0])
+	}
+	for _, key := range keys {
+		// An empty value counts as unset
+		t.Setenv(key, "")
+	}
+}
+
+// setRequired sets the settings every deployment must provide
+func setRequired(t *testing.T) {
+	t.Helper()
+	for key, value := range map[string]string{
+		"TOKEN":     "123:abc",
+		"APP_ID":    "1234",
+		"APP_HASH":  "0123456789abcdef",
+		"API_KEY":   "secret-key",
+		"API_URL":   "https://api.example.com",
+		"OWNER_IDS": "42",
+	} {
+		t.Setenv(key, value)
+	}
+}
+
+// errorKeys returns the settings named in the errors of Load
+func errorKeys(err error) map[string]bool {
+	keys := make(map[string]bool)
+	if err == nil {
+		return keys
+	}
+	for _, line := range strings.Split(err.Error(), "\n") {
+		key, _, _ := strings.Cut(line, ":")
+		keys[key] = true
+	}
+	return keys
+}
+
+func TestLoadEnv(t *testing.T) {
+	clearEnv(t)
+	setRequired(t)
+	t.Setenv("TOKEN", "1:a, 2:b,,")
+	t.Setenv("OWNER_IDS", "42, 43")
+	t.Setenv("SUDO_IDS", "7")
+	t.Setenv("DOWNLOAD_TIMEOUT", "90s")
+	t.Setenv("ZIP_PART_SIZE", "50MB")
+	t.Setenv("RATE_LIMIT_TRACKS", "10/30s")
+	t.Setenv("RATE_LIMIT_SEARCH", "0/1m")
+	t.Setenv("HISTORY_ENABLED", "yes")
+	t.Setenv("RESTART_MEMORY_LIMIT", "off")
+	t.Setenv("RESTART_WEBHOOK_URL", "https://deploy.example.com/restart")
+	t.Setenv("RESTART_WEBHOOK_HEADERS", "Authorization: Bearer abc, X-Team: music")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Tokens) != 2 || c.Tokens[1] != "2:b" {
+		t.Errorf("tokens = %q", c.Tokens)
+	}
+	if !c.IsOwner(43) || c.IsOwner(7) || !c.IsSudo(7) || c.IsSudo(8) {
+		t.Errorf("owners %v, sudo users %v", c.OwnerIDs, c.SudoIDs)
+	}
+	if c.DownloadTimeout != 90*time.Second || c.ZipPartSize != 50<<20 || !c.HistoryEnabled {
+		t.Errorf("timeout %s, part size %d, history %v", c.DownloadTimeout, c.ZipPartSize, c.HistoryEnabled)
+	}
+	if c.TrackRate != (Rate{Count: 10, Per: 30 * time.Second}) || c.SearchRate.String() != "off" {
+		t.Errorf("track rate %s, search rate %s", c.TrackRate, c.SearchRate)
+	}
+	// A webhook URL turns on webhook restarts unless RESTART_MODE says otherwise
+	if c.RestartMode != "webhook" || c.RestartMemoryLimit != 0 || c.RestartWebhookHeaders["X-Team"] != "music" {
+		t.Errorf("restart mode %s, memory limit %d, headers %v", c.RestartMode, c.RestartMemoryLimit, c.RestartWebhookHeaders)
+	}
+	// Unset settings keep their defaults
+	if c.QueueWorkers != 4 || c.InlineRate != (Rate{Count: 60, Per: time.Minute}) {
+		t.Errorf("workers %d, inline rate %s", c.QueueWorkers, c.InlineRate)
+	}
+}
+
+func TestLoadRequired(t *testing.T) {
+	clearEnv(t)
+
+	c, err := Load()
+	keys := errorKeys(err)
+	for _, key := range []string{"TOKEN", "APP_ID", "APP_HASH", "API_KEY", "API_URL", "OWNER_IDS"} {
+		if !keys[key] {
+			t.Errorf("no error for the missing %s", key)
+		}
+	}
+	// Nobody owns a bot that was not told who does
+	if len(c.OwnerIDs) != 0 || c.AppID != 0 || c.AppHash != "" {
+		t.Errorf("built-in defaults: owners %v, app %d/%q", c.OwnerIDs, c.AppID, c.AppHash)
+	}
+
+	// The fake provider needs no API
+	t.Setenv("PROVIDER", "fake")
+	_, err = Load()
+	if keys := errorKeys(err); keys["API_KEY"] || keys["API_URL"] {
+		t.Errorf("the fake provider requires the API: %v", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		key, value string
+	}{
+		{"APP_ID", "twelve"},
+		{"OWNER_IDS", "42, someone"},
+		{"API_URL", "api.example.com"},
+		{"PROVIDER", "cdn"},
+		{"QUEUE_WORKERS", "0"},
+		{"DOWNLOAD_TIMEOUT", "-1s"},
+		{"ZIP_PART_SIZE", "100KB"},
+		{"DOWNLOADS_QUOTA", "lots"},
+		{"RATE_LIMIT_TRACKS", "5"},
+		{"HISTORY_ENABLED", "maybe"},
+		{"HTTP_ADDR", "9090"},
+		{"RESTART_MODE", "sometimes"},
+		{"RESTART_INTERVAL", "daily"},
+		{"RESTART_WEBHOOK_HEADERS", "no colon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			clearEnv(t)
+			setRequired(t)
+			t.Setenv(tt.key, tt.value)
+			_, err := Load()
+			if keys := errorKeys(err); !keys[tt.key] || len(keys) != 1 {
+				t.Errorf("%s=%q: %v", tt.key, tt.value, err)
+			}
+		})
+	}
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	clearEnv(t)
+	setRequired(t)
+	t.Setenv("QUEUE_WORKERS", "none")
+	t.Setenv("SEARCH_LIMIT", "-1")
+	t.Setenv("RATE_LIMIT_PLAYLISTS", "2/forever")
+
+	c, err := Load()
+	keys := errorKeys(err)
+	if len(keys) != 3 || !keys["QUEUE_WORKERS"] || !keys["SEARCH_LIMIT"] || !keys["RATE_LIMIT_PLAYLISTS"] {
+		t.Errorf("errors: %v", err)
+	}
+	// A value that does not parse falls back to its default
+	if c.QueueWorkers != 4 || c.PlaylistRate != (Rate{Count: 2, Per: 10 * time.Minute}) {
+		t.Errorf("workers %d, playlist rate %s", c.QueueWorkers, c.PlaylistRate)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	files := map[string]string{
+		"bot.yaml": `---
+# Bot settings
+token: ["1:a", '2:b']
+app_id: 1234
+APP_HASH: "0123#456789abcdef" # quoted, so the # is kept
+api_key: secret-key
+api_url: https://api.example.com
+owner_ids: [42, 43]
+search_limit: 9
+`,
+		"bot.toml": `[bot]
+token = ["1:a", "2:b"]
+app_id = 1234
+app_hash = '0123#456789abcdef'
+api_key = "secret-key"
+api_url = "https://api.example.com" # trailing comment
+owner_ids = [42, 43]
+search_limit = 9
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CONFIG_FILE", path)
+			// The environment wins over the file
+			t.Setenv("SEARCH_LIMIT", "3")
+
+			c, err := Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(c.Tokens) != 2 || c.Tokens[1] != "2:b" || c.AppHash != "0123#456789abcdef" {
+				t.Errorf("tokens %q, app hash %q", c.Tokens, c.AppHash)
+			}
+			if !c.IsOwner(43) || c.AppID != 1234 || c.SearchLimit != 3 {
+				t.Errorf("owners %v, app id %d, search limit %d", c.OwnerIDs, c.AppID, c.SearchLimit)
+			}
+		})
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"bot.json":     `{"token": "1:a"}`,
+		"broken.yaml":  "token\n",
+		"list.toml":    "owner_ids = [42, 43\n",
+		"missing.yaml": "",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+			setRequired(t)
+			path := filepath.Join(dir, name)
+			if name != "missing.yaml" {
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("CONFIG_FILE", path)
+
+			_, err := Load()
+			if keys := errorKeys(err); !keys["CONFIG_FILE"] {
+				t.Errorf("no CONFIG_FILE error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDescribeRedacts(t *testing.T) {
+	clearEnv(t)
+	setRequired(t)
+	t.Setenv("RESTART_WEBHOOK_URL", "https://deploy.example.com/apps/secret-app-id/restart")
+	t.Setenv("RESTART_WEBHOOK_HEADERS", "Authorization: Bearer secret-bearer-token")
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var all strings.Builder
+	for _, kv := range c.Describe() {
+		all.WriteString(kv[0] + "=" + kv[1] + "\n")
+	}
+	for _, secret := range []string{"123:abc", "secret-key", "0123456789abcdef", "secret-app-id", "secret-bearer-token"} {
+		if strings.Contains(all.String(), secret) {
+			t.Errorf("Describe shows %q:\n%s", secret, all.String())
+		}
+	}
+	if !strings.Contains(all.String(), "OWNER_IDS=42\n") || !strings.Contains(all.String(), "deploy.example.com") {
+		t.Errorf("Describe hides too much:\n%s", all.String())
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readFile reads a flat configuration file. Keys are the environment variable names in any case
+// (api_key or API_KEY). YAML files use "key: value", TOML files "key = value"; both accept
+// # comments, quoted strings and [a, b] lists. Nested tables and multi-line values are not supported.
+func readFile(path string) (map[string]string, error) {
+	var sep string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		sep = ":"
+	case ".toml":
+		sep = "="
+	default:
+		return nil, fmt.Errorf("%s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" || line == "---" || strings.HasPrefix(line, "[") {
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, sep)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key%svalue", path, lineNo, sep)
+		}
+
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		values[strings.ToUpper(strings.TrimSpace(key))] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// stripComment drops a # comment that is not inside quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseValue unquotes a scalar and joins a [a, b] list with commas, as the environment would hold it
+func parseValue(raw string) (string, error) {
+	if !strings.HasPrefix(raw, "[") {
+		return unquote(raw)
+	}
+	if !strings.HasSuffix(raw, "]") {
+		return "", fmt.Errorf("unterminated list %q", raw)
+	}
+
+	var items []string
+	for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+		item, err := unquote(strings.TrimSpace(item))
+		if err != nil {
+			return "", err
+		}
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ","), nil
+}
+
+func unquote(raw string) (string, error) {
+	if len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+		return raw[1 : len(raw)-1], nil
+	}
+	if strings.HasPrefix(raw, `"`) {
+		return strconv.Unquote(raw)
+	}
+	return raw, nil
+}
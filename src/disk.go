@@ -0,0 +1,67 @@
+package src
+
+import (
+	"fmt"
+	"html"
+	"songBot/src/config"
+	"songBot/src/utils"
+	"strings"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// diskHandle shows what the downloads directory holds and what the janitor last removed
+func diskHandle(m *telegram.NewMessage) error {
+	usage, err := utils.ScanDownloads(config.Cfg.DownloadPath)
+	if err != nil {
+		_, _ = m.Reply("❌ Failed to read the downloads directory: " + html.EscapeString(err.Error()))
+		return nil
+	}
+
+	_, err = m.Reply(renderDiskUsage(usage, utils.LastSweep(), time.Now()))
+	return err
+}
+
+// renderDiskUsage writes the usage of the downloads directory as the /disk message
+func renderDiskUsage(usage, sweep utils.DiskUsage, now time.Time) string {
+	quota := config.Cfg.DownloadsQuota
+
+	var sb strings.Builder
+	sb.WriteString("<b>💾 Downloads</b>\n")
+	sb.WriteString(fmt.Sprintf("Path: <code>%s</code>\n\n", html.EscapeString(config.Cfg.DownloadPath)))
+	sb.WriteString(fmt.Sprintf("Files: <b>%d</b> · <b>%s</b> of %s (%.1f%%)\n",
+		usage.Files, formatBytes(usage.Bytes), formatBytes(quota), float64(usage.Bytes)/float64(quota)*100))
+	sb.WriteString(fmt.Sprintf("Temporary: <b>%d</b> · %s\n", usage.TempFiles, formatBytes(usage.TempBytes)))
+	sb.WriteString(fmt.Sprintf("In use by downloads: <b>%d</b>\n", usage.Held))
+	if !usage.Oldest.IsZero() {
+		sb.WriteString(fmt.Sprintf("Least recently used: %s ago\n", now.Sub(usage.Oldest).Round(time.Minute)))
+	}
+
+	sb.WriteString("\n<b>🧹 Janitor</b>\n")
+	if sweep.SweptAt.IsZero() {
+		sb.WriteString("No sweep yet.\n")
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf("Last sweep: %s ago, every %s\n",
+		now.Sub(sweep.SweptAt).Round(time.Second), config.Cfg.JanitorInterval))
+	sb.WriteString(fmt.Sprintf("Evicted: %d files (%s) · Stale temporary files removed: %d\n",
+		sweep.Evicted, formatBytes(sweep.EvictedBytes), sweep.RemovedTemp))
+	return sb.String()
+}
+
+// formatBytes writes a size with a binary unit, e.g. "1.5 GB"
+func formatBytes(n int64) string {
+	units := []string{"KB", "MB", "GB", "TB"}
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size, unit := float64(n)/(1<<10), units[0]
+	for _, u := range units[1:] {
+		if size < 1<<10 {
+			break
+		}
+		size, unit = size/(1<<10), u
+	}
+	return fmt.Sprintf("%.1f %s", size, unit)
+}
@@ -0,0 +1,296 @@
+package src
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"songBot/src/config"
+	"songBot/src/utils"
+	"strconv"
+	"strings"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// favoritesPerPage is how many tracks a /favorites page shows
+const favoritesPerPage = 8
+
+// favoritesUsage explains the /favorites subcommands
+const favoritesUsage = `<b>Usage:</b>
+<code>/favorites</code> - your favorite tracks
+<code>/favorites zip</code> - download them all as a ZIP
+<code>/favorites json</code> or <code>/favorites m3u</code> - export the list
+Tap ❤️ under a track to add or remove it.`
+
+// favoritesHandle lists, downloads or exports the favorites of the user: /favorites [zip|json|m3u]
+func favoritesHandle(m *telegram.NewMessage) error {
+	userID := m.SenderID()
+	arg := strings.ToLower(strings.TrimSpace(m.Args()))
+	if arg != "" && arg != "zip" && arg != "json" && arg != "m3u" {
+		_, err := m.Reply(favoritesUsage)
+		return err
+	}
+
+	favorites, err := utils.GetFavorites(userID)
+	if err != nil {
+		m.Client.Logger.Warn("Failed to load favorites:", err)
+		_, _ = m.Reply("❌ Failed to load your favorites.")
+		return nil
+	}
+	if len(favorites) == 0 {
+		_, err := m.Reply("💔 You have no favorites yet.\n\n" + favoritesUsage)
+		return err
+	}
+
+	switch arg {
+	case "zip":
+		if ok, wait := playlistBudget.allow(userID, m.ChatID()); !ok {
+			_, _ = m.Reply(rateLimitText(wait))
+			return nil
+		}
+		msg, err := m.Reply(fmt.Sprintf("⏳ Found %d tracks. Preparing download...", len(favorites)))
+		if err != nil {
+			return nil
+		}
+		queueFavoritesZip(userID, msg, favorites)
+	case "json", "m3u":
+		if err := sendFavoritesExport(m, favorites, arg); err != nil {
+			m.Client.Logger.Warn("Failed to export favorites:", err)
+			_, _ = m.Reply("❌ Failed to export your favorites.")
+		}
+	default:
+		text, markup := favoritesPage(userID, favorites, 0)
+		_, err = m.Reply(text, telegram.SendOptions{ReplyMarkup: markup})
+	}
+	return err
+}
+
+// favoritesPage is the text and keyboard of one page of a user's favorites
+func favoritesPage(userID int64, favorites []utils.Favorite, page int) (string, telegram.ReplyMarkup) {
+	pages := max(1, (len(favorites)+favoritesPerPage-1)/favoritesPerPage)
+	page = min(max(page, 0), pages-1)
+	start := page * favoritesPerPage
+	end := min(start+favoritesPerPage, len(favorites))
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>❤️ Your favorites</b> (%d)\n\n", len(favorites)))
+	kb := telegram.NewKeyboard()
+	for i, f := range favorites[start:end] {
+		sb.WriteString(fmt.Sprintf("%d. <b>%s</b> — %s\n", start+i+1, html.EscapeString(f.Name), html.EscapeString(f.Artist)))
+		kb.AddRow(telegram.Button.Data(fmt.Sprintf("🔁 %s - %s", f.Name, f.Artist), fmt.Sprintf("favsend_%d_%s", userID, f.TrackID)))
+	}
+
+	if pages > 1 {
+		sb.WriteString(fmt.Sprintf("\nPage %d of %d", page+1, pages))
+		prev, next := (page+pages-1)%pages, (page+1)%pages
+		kb.AddRow(
+			telegram.Button.Data("◀️", fmt.Sprintf("favnav_%d_%d", userID, prev)),
+			telegram.Button.Data(fmt.Sprintf("%d/%d", page+1, pages), fmt.Sprintf("favnav_%d_%d", userID, page)),
+			telegram.Button.Data("▶️", fmt.Sprintf("favnav_%d_%d", userID, next)),
+		)
+	}
+	kb.AddRow(telegram.Button.Data(fmt.Sprintf("📦 Download favorites as ZIP (%d)", len(favorites)), fmt.Sprintf("favzip_%d", userID)))
+	kb.AddRow(
+		telegram.Button.Data("📄 Export JSON", fmt.Sprintf("favexport_%d_json", userID)),
+		telegram.Button.Data("🎶 Export M3U", fmt.Sprintf("favexport_%d_m3u", userID)),
+	)
+	return sb.String(), kb.Build()
+}
+
+// queueFavoritesZip archives the favorites through the same queue and ZIP path as /playlist
+func queueFavoritesZip(userID int64, msg *telegram.NewMessage, favorites []utils.Favorite) {
+	tracks := &utils.PlatformTracks{Results: make([]utils.MusicTrack, len(favorites))}
+	for i := range favorites {
+		tracks.Results[i] = favorites[i].Track()
+	}
+	queuePlaylistZip(userID, "Favorites", msg, tracks, utils.GetUserFormat(userID))
+}
+
+// sendFavoritesExport sends the favorites as a favorites.json or favorites.m3u file below msg
+func sendFavoritesExport(msg *telegram.NewMessage, favorites []utils.Favorite, format string) error {
+	var data []byte
+	if format == "json" {
+		var err error
+		if data, err = utils.FavoritesJSON(favorites); err != nil {
+			return err
+		}
+	} else {
+		data = []byte(utils.FavoritesM3U(favorites))
+	}
+
+	file, err := os.CreateTemp(config.Cfg.DownloadPath, "favorites_*."+format)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+
+	_, err = msg.RespondMedia(file.Name(), telegram.MediaOptions{
+		FileName:      "favorites." + format,
+		Caption:       fmt.Sprintf("❤️ %d favorite tracks", len(favorites)),
+		ForceDocument: true,
+	})
+	return err
+}
+
+// favoriteCallback adds a track to the favorites of whoever taps ❤️, or removes it: fav_<tc>
+func favoriteCallback(cb *telegram.CallbackQuery) error {
+	_, _ = cb.Answer(toggleFavorite(cb.Client, cb.SenderID, strings.TrimPrefix(cb.DataString(), "fav_")))
+	return nil
+}
+
+// inlineFavoriteCallback is favoriteCallback for the ❤️ button of an inline message
+func inlineFavoriteCallback(cb *telegram.InlineCallbackQuery) error {
+	_, _ = cb.Answer(toggleFavorite(cb.Client, cb.SenderID, strings.TrimPrefix(cb.DataString(), "fav_")))
+	return nil
+}
+
+// toggleFavorite adds a track to the favorites of userID or removes it, and returns the answer to the tap
+func toggleFavorite(client *telegram.Client, userID int64, tc string) (string, *telegram.CallbackOptions) {
+	added, err := utils.ToggleFavorite(userID, tc)
+	switch {
+	case utils.IsFavoritesFull(err):
+		return fmt.Sprintf("💔 Your favorites are full (%d tracks). Remove some first.", config.Cfg.FavoritesLimit), &telegram.CallbackOptions{Alert: true}
+	case err != nil:
+		client.Logger.Warn("Failed to update favorites:", err)
+		return "❌ Could not update your favorites.", &telegram.CallbackOptions{Alert: true}
+	case added:
+		return "❤️ Added to your /favorites", &telegram.CallbackOptions{}
+	default:
+		return "💔 Removed from your /favorites", &telegram.CallbackOptions{}
+	}
+}
+
+// loadFavorites parses <prefix>_<uid>[_<arg>] and returns the favorites of its owner with arg,
+// answering the callback when they can't be used
+func loadFavorites(cb *telegram.CallbackQuery) ([]utils.Favorite, string, bool) {
+	// The last part may be a TC, which could hold underscores
+	parts := strings.SplitN(cb.DataString(), "_", 3)
+	if len(parts) < 2 {
+		_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
+		return nil, "", false
+	}
+	if parts[1] != strconv.FormatInt(cb.SenderID, 10) {
+		_, _ = cb.Answer("🚫 This action is not meant for you.", &telegram.CallbackOptions{Alert: true})
+		return nil, "", false
+	}
+
+	favorites, err := utils.GetFavorites(cb.SenderID)
+	if err != nil || len(favorites) == 0 {
+		_, _ = cb.Answer("💔 You have no favorites.", &telegram.CallbackOptions{Alert: true})
+		return nil, "", false
+	}
+
+	arg := ""
+	if len(parts) == 3 {
+		arg = parts[2]
+	}
+	return favorites, arg, true
+}
+
+// favoritesPageCallback switches a /favorites message to another page: favnav_<uid>_<page>
+func favoritesPageCallback(cb *telegram.CallbackQuery) error {
+	favorites, arg, ok := loadFavorites(cb)
+	if !ok {
+		return nil
+	}
+
+	page, err := strconv.Atoi(arg)
+	if err != nil {
+		_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	text, markup := favoritesPage(cb.SenderID, favorites, page)
+	_, _ = cb.Edit(text, &telegram.SendOptions{ReplyMarkup: markup})
+	_, _ = cb.Answer("")
+	return nil
+}
+
+// favoriteSendCallback sends a favorite again: favsend_<uid>_<tc>.
+// Without a usable file_id the user gets a button that downloads the track again.
+func favoriteSendCallback(cb *telegram.CallbackQuery) error {
+	favorites, tc, ok := loadFavorites(cb)
+	if !ok {
+		return nil
+	}
+
+	var favorite *utils.Favorite
+	for i := range favorites {
+		if favorites[i].TrackID == tc {
+			favorite = &favorites[i]
+			break
+		}
+	}
+	if favorite == nil {
+		_, _ = cb.Answer("💔 This track is no longer in your favorites.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	if stored, ok := utils.GetStoredTrack(tc); ok && stored.FileID != "" && stored.BotID == botID(cb.Client) {
+		if media, err := telegram.ResolveBotFileID(stored.FileID); err == nil {
+			track := stored.Track(tc)
+			opts := prepareTrackMessageOptions(media, nil, track, nil)
+			if _, err = cb.Respond(buildTrackCaption(track), &opts); err == nil {
+				_, _ = cb.Answer("")
+				return nil
+			}
+			cb.Client.Logger.Warn("Failed to re-send favorite:", err)
+		}
+	}
+
+	_, _ = cb.Answer("")
+	kb := telegram.NewKeyboard().AddRow(telegram.Button.Data(
+		"⬇️ Download again", fmt.Sprintf("spot_%s_%d", utils.EncodeURL(favorite.Source), cb.SenderID),
+	))
+	_, _ = cb.Respond(fmt.Sprintf("<b>🎵 %s</b> — %s", html.EscapeString(favorite.Name), html.EscapeString(favorite.Artist)),
+		&telegram.SendOptions{ReplyMarkup: kb.Build()})
+	return nil
+}
+
+// favoritesZipCallback queues the favorites as a ZIP: favzip_<uid>
+func favoritesZipCallback(cb *telegram.CallbackQuery) error {
+	favorites, _, ok := loadFavorites(cb)
+	if !ok {
+		return nil
+	}
+
+	_, _ = cb.Answer("📦 Preparing the ZIP...")
+	msg, err := cb.Respond(fmt.Sprintf("⏳ Found %d tracks. Preparing download...", len(favorites)))
+	if err != nil {
+		return nil
+	}
+	queueFavoritesZip(cb.SenderID, msg, favorites)
+	return nil
+}
+
+// favoritesExportCallback sends the favorites as a file: favexport_<uid>_<json|m3u>
+func favoritesExportCallback(cb *telegram.CallbackQuery) error {
+	favorites, format, ok := loadFavorites(cb)
+	if !ok {
+		return nil
+	}
+	if format != "json" && format != "m3u" {
+		_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	msg, err := cb.GetMessage()
+	if err != nil {
+		_, _ = cb.Answer("❌ Failed to export your favorites.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	_, _ = cb.Answer("📄 Exporting...")
+	if err := sendFavoritesExport(msg, favorites, format); err != nil {
+		cb.Client.Logger.Warn("Failed to export favorites:", err)
+		_, _ = cb.Respond("❌ Failed to export your favorites.")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package src
+
+import (
+	"fmt"
+	"songBot/src/utils"
+	"strconv"
+	"strings"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// parseFormatFlag removes a "-f <format>" (or "--format <format>") override from a query
+func parseFormatFlag(query string) (string, *utils.AudioFormat, error) {
+	fields := strings.Fields(query)
+	for i, field := range fields {
+		if field != "-f" && field != "--format" {
+			continue
+		}
+		if i+1 == len(fields) {
+			return query, nil, fmt.Errorf("missing format after %s", field)
+		}
+
+		f, err := utils.ParseFormat(fields[i+1])
+		if err != nil {
+			return query, nil, err
+		}
+		rest := append(fields[:i:i], fields[i+2:]...)
+		return strings.Join(rest, " "), &f, nil
+	}
+	return query, nil, nil
+}
+
+// requestFormat returns the format of one request: the override when given, else the user's preference
+func requestFormat(userID int64, override *utils.AudioFormat) utils.AudioFormat {
+	if override != nil {
+		return *override
+	}
+	return utils.GetUserFormat(userID)
+}
+
+// formatUsage lists the accepted formats
+func formatUsage() string {
+	var sb strings.Builder
+	sb.WriteString("<b>Formats:</b> <code>original</code>")
+	for _, name := range utils.CodecNames {
+		sb.WriteString(", <code>" + name + "</code>")
+		if rates := utils.Bitrates(name); len(rates) > 0 {
+			sb.WriteString(fmt.Sprintf(" (<code>%s-%d</code>…)", name, rates[len(rates)-1]))
+		}
+	}
+	sb.WriteString("\nUse <code>-f mp3</code> in /spotify, /playlist or inline queries to override it once.")
+	return sb.String()
+}
+
+// formatMenu is the text and keyboard of /format; codecName, when set, opens its bitrate choices
+func formatMenu(userID int64, codecName string) (string, telegram.ReplyMarkup) {
+	current := utils.GetUserFormat(userID)
+	text := fmt.Sprintf("<b>🎚 Output format</b>\n\nCurrent: <b>%s</b>\n\n%s", current.Label(), formatUsage())
+	mark := func(label string, selected bool) string {
+		if selected {
+			return "✅ " + label
+		}
+		return label
+	}
+
+	kb := telegram.NewKeyboard()
+	if rates := utils.Bitrates(codecName); len(rates) > 0 {
+		var row []telegram.KeyboardButton
+		for _, rate := range rates {
+			f := utils.AudioFormat{Codec: codecName, Bitrate: rate}
+			row = append(row, telegram.Button.Data(mark(strconv.Itoa(rate)+"k", f == current), "fmt_"+f.String()))
+		}
+		kb.AddRow(row...)
+		kb.AddRow(telegram.Button.Data("⬅️ Back", "fmt_menu"))
+		return text, kb.Build()
+	}
+
+	row := []telegram.KeyboardButton{telegram.Button.Data(mark("Original", current.IsOriginal()), "fmt_original")}
+	for _, name := range utils.CodecNames {
+		row = append(row, telegram.Button.Data(mark(utils.CodecLabel(name), current.Codec == name), "fmt_"+name))
+		if len(row) == 3 {
+			kb.AddRow(row...)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		kb.AddRow(row...)
+	}
+	return text, kb.Build()
+}
+
+// formatHandle shows the format menu, or sets the format directly with "/format mp3-192"
+func formatHandle(m *telegram.NewMessage) error {
+	if arg := strings.TrimSpace(m.Args()); arg != "" {
+		f, err := utils.ParseFormat(arg)
+		if err != nil {
+			_, _ = m.Reply("❌ " + err.Error() + "\n\n" + formatUsage())
+			return nil
+		}
+		if err := utils.SetUserFormat(m.SenderID(), f); err != nil {
+			_, _ = m.Reply("⚠️ Failed to save your format.")
+			return nil
+		}
+		_, _ = m.Reply("✅ Tracks will be sent as <b>" + f.Label() + "</b>.")
+		return nil
+	}
+
+	text, markup := formatMenu(m.SenderID(), "")
+	_, err := m.Reply(text, telegram.SendOptions{ReplyMarkup: markup})
+	return err
+}
+
+// formatCallback handles the buttons of the format menu; each user only changes their own preference
+func formatCallback(cb *telegram.CallbackQuery) error {
+	choice := strings.TrimPrefix(cb.DataString(), "fmt_")
+	if choice == "menu" {
+		text, markup := formatMenu(cb.SenderID, "")
+		_, _ = cb.Edit(text, &telegram.SendOptions{ReplyMarkup: markup})
+		return nil
+	}
+
+	// A codec with several bitrates first asks for the bitrate
+	if len(utils.Bitrates(choice)) > 0 {
+		text, markup := formatMenu(cb.SenderID, choice)
+		_, _ = cb.Edit(text, &telegram.SendOptions{ReplyMarkup: markup})
+		return nil
+	}
+
+	f, err := utils.ParseFormat(choice)
+	if err != nil {
+		_, _ = cb.Answer("❌ Unknown format.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	if err := utils.SetUserFormat(cb.SenderID, f); err != nil {
+		_, _ = cb.Answer("⚠️ Failed to save your format.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	_, _ = cb.Answer("✅ Format set to " + f.Label())
+	text, markup := formatMenu(cb.SenderID, "")
+	_, _ = cb.Edit(text, &telegram.SendOptions{ReplyMarkup: markup})
+	return nil
+}
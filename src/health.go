@@ -0,0 +1,101 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"songBot/src/config"
+	"songBot/src/utils"
+	"sync"
+	"time"
+)
+
+// apiCheckTTL is how long the result of the API check is reused, so frequent probes do not load the API
+const apiCheckTTL = 30 * time.Second
+
+// HealthCheck is the outcome of one readiness check
+type HealthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var (
+	apiCheck   HealthCheck
+	apiChecked time.Time
+	apiCheckMu sync.Mutex
+)
+
+// Readiness runs every readiness check concurrently; the bot is ready when all of them pass
+func Readiness(ctx context.Context) (bool, []HealthCheck) {
+	checks := []func(context.Context) HealthCheck{checkTelegram, checkAPI, checkDownloads, checkFFmpeg}
+	results := make([]HealthCheck, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	ready := true
+	for _, result := range results {
+		ready = ready && result.OK
+	}
+	return ready, results
+}
+
+// checkTelegram passes when at least one client of the pool is connected and logged in
+func checkTelegram(context.Context) HealthCheck {
+	statuses := BotStatuses()
+	online := 0
+	for _, status := range statuses {
+		if status.Connected && status.Username != "" {
+			online++
+		}
+	}
+	return HealthCheck{Name: "telegram", OK: online > 0, Detail: fmt.Sprintf("%d/%d clients online", online, len(statuses))}
+}
+
+// checkAPI pings the music provider, reusing a recent result
+func checkAPI(ctx context.Context) HealthCheck {
+	apiCheckMu.Lock()
+	defer apiCheckMu.Unlock()
+
+	if time.Since(apiChecked) < apiCheckTTL {
+		return apiCheck
+	}
+
+	apiCheck = HealthCheck{Name: "api", OK: true, Detail: config.Cfg.Provider}
+	if err := utils.Provider().Ping(ctx); err != nil {
+		apiCheck.OK, apiCheck.Detail = false, err.Error()
+	}
+	apiChecked = time.Now()
+	return apiCheck
+}
+
+// checkDownloads creates and removes a file in the downloads directory
+func checkDownloads(context.Context) HealthCheck {
+	check := HealthCheck{Name: "downloads", OK: true, Detail: config.Cfg.DownloadPath}
+	f, err := os.CreateTemp(config.Cfg.DownloadPath, ".readyz-*")
+	if err != nil {
+		check.OK, check.Detail = false, err.Error()
+		return check
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return check
+}
+
+// checkFFmpeg looks for the ffmpeg binary used by /format
+func checkFFmpeg(context.Context) HealthCheck {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return HealthCheck{Name: "ffmpeg", Detail: err.Error()}
+	}
+	return HealthCheck{Name: "ffmpeg", OK: true, Detail: path}
+}
@@ -0,0 +1,192 @@
+package src
+
+import (
+	"fmt"
+	"html"
+	"songBot/src/config"
+	"songBot/src/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// historyPerPage is how many tracks a /history page shows
+const historyPerPage = 8
+
+// historyUsage explains the /history subcommands
+const historyUsage = `<b>Usage:</b>
+<code>/history</code> - your recently downloaded tracks
+<code>/history on</code> - start recording your downloads
+<code>/history off</code> - stop recording and delete your history
+<code>/history clear</code> - delete the recorded tracks`
+
+// historyHandle shows and manages the download history of the user: /history [on|off|clear]
+func historyHandle(m *telegram.NewMessage) error {
+	userID := m.SenderID()
+
+	switch arg := strings.ToLower(strings.TrimSpace(m.Args())); arg {
+	case "off":
+		if err := utils.SetHistoryEnabled(userID, false); err != nil {
+			m.Client.Logger.Warn("Failed to disable history:", err)
+			_, _ = m.Reply("❌ Failed to update your history settings.")
+			return nil
+		}
+		_, err := m.Reply("🗑 History turned off and deleted.")
+		return err
+	case "clear":
+		if err := utils.ClearHistory(userID); err != nil {
+			m.Client.Logger.Warn("Failed to clear history:", err)
+			_, _ = m.Reply("❌ Failed to clear your history.")
+			return nil
+		}
+		_, err := m.Reply("🗑 Your history has been cleared.")
+		return err
+	case "", "on":
+		if !config.Cfg.HistoryEnabled {
+			_, err := m.Reply("🔒 Download history is disabled on this bot.")
+			return err
+		}
+		if arg == "on" {
+			if err := utils.SetHistoryEnabled(userID, true); err != nil {
+				m.Client.Logger.Warn("Failed to enable history:", err)
+				_, _ = m.Reply("❌ Failed to update your history settings.")
+				return nil
+			}
+			_, err := m.Reply("✅ History turned on. Tracks you download from now on will show up in /history.\n\n" + historyUsage)
+			return err
+		}
+	default:
+		_, err := m.Reply(historyUsage)
+		return err
+	}
+
+	h, err := utils.GetHistory(userID)
+	if err != nil {
+		m.Client.Logger.Warn("Failed to load history:", err)
+		_, _ = m.Reply("❌ Failed to load your history.")
+		return nil
+	}
+	if !h.Enabled {
+		_, err := m.Reply("📜 Your download history is off. Nothing is recorded until you turn it on.\n\n" + historyUsage)
+		return err
+	}
+
+	text, markup := historyPage(userID, h, 0)
+	_, err = m.Reply(text, telegram.SendOptions{ReplyMarkup: markup})
+	return err
+}
+
+// historyPage is the text and keyboard of one page of a user's history
+func historyPage(userID int64, h *utils.UserHistory, page int) (string, telegram.ReplyMarkup) {
+	if len(h.Entries) == 0 {
+		return "📜 Your history is empty. Tracks you download will show up here.", nil
+	}
+
+	entries, page, pages := h.Page(page, historyPerPage)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>📜 Your downloads</b> (%d)\n\n", len(h.Entries)))
+	kb := telegram.NewKeyboard()
+	for i, e := range entries {
+		sb.WriteString(fmt.Sprintf("%d. <b>%s</b> — %s\n<i>%s", page*historyPerPage+i+1,
+			html.EscapeString(e.Name), html.EscapeString(e.Artist), time.Unix(e.Time, 0).UTC().Format("2 Jan 2006 15:04")))
+		if e.Platform != "" {
+			sb.WriteString(", " + html.EscapeString(e.Platform))
+		}
+		sb.WriteString("</i>\n")
+		kb.AddRow(telegram.Button.Data(fmt.Sprintf("🔁 %s - %s", e.Name, e.Artist), fmt.Sprintf("histsend_%d_%d", userID, e.ID)))
+	}
+
+	if pages > 1 {
+		sb.WriteString(fmt.Sprintf("\nPage %d of %d", page+1, pages))
+		prev, next := (page+pages-1)%pages, (page+1)%pages
+		kb.AddRow(
+			telegram.Button.Data("◀️", fmt.Sprintf("histnav_%d_%d", userID, prev)),
+			telegram.Button.Data(fmt.Sprintf("%d/%d", page+1, pages), fmt.Sprintf("histnav_%d_%d", userID, page)),
+			telegram.Button.Data("▶️", fmt.Sprintf("histnav_%d_%d", userID, next)),
+		)
+	}
+	return sb.String(), kb.Build()
+}
+
+// loadHistory parses <prefix>_<uid>_<n> and returns the history of its owner, answering the
+// callback when it can't be used
+func loadHistory(cb *telegram.CallbackQuery) (*utils.UserHistory, int, bool) {
+	parts := strings.Split(cb.DataString(), "_")
+	if len(parts) != 3 {
+		_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
+		return nil, 0, false
+	}
+
+	n, err := strconv.Atoi(parts[2])
+	if err != nil {
+		_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
+		return nil, 0, false
+	}
+	if parts[1] != strconv.FormatInt(cb.SenderID, 10) {
+		_, _ = cb.Answer("🚫 This action is not meant for you.", &telegram.CallbackOptions{Alert: true})
+		return nil, 0, false
+	}
+
+	h, err := utils.GetHistory(cb.SenderID)
+	if err != nil || !h.Enabled {
+		_, _ = cb.Answer("📜 Your history is off or could not be loaded.", &telegram.CallbackOptions{Alert: true})
+		return nil, 0, false
+	}
+	return h, n, true
+}
+
+// historyPageCallback switches a /history message to another page: histnav_<uid>_<page>
+func historyPageCallback(cb *telegram.CallbackQuery) error {
+	h, page, ok := loadHistory(cb)
+	if !ok {
+		return nil
+	}
+
+	text, markup := historyPage(cb.SenderID, h, page)
+	_, _ = cb.Edit(text, &telegram.SendOptions{ReplyMarkup: markup})
+	_, _ = cb.Answer("")
+	return nil
+}
+
+// historySendCallback sends a track of the history again: histsend_<uid>_<id>.
+// Without a usable file_id the user gets a button that downloads the track again.
+func historySendCallback(cb *telegram.CallbackQuery) error {
+	h, id, ok := loadHistory(cb)
+	if !ok {
+		return nil
+	}
+
+	entry, found := h.Find(id)
+	if !found {
+		_, _ = cb.Answer("⌛ This track is no longer in your history.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	track := entry.Track()
+	if entry.FileID != "" && entry.BotID == botID(cb.Client) {
+		if media, err := telegram.ResolveBotFileID(entry.FileID); err == nil {
+			opts := prepareTrackMessageOptions(media, nil, track, nil)
+			if _, err = cb.Respond(buildTrackCaption(track), &opts); err == nil {
+				_, _ = cb.Answer("")
+				return nil
+			}
+			cb.Client.Logger.Warn("Failed to re-send history track:", err)
+		}
+	}
+
+	if entry.Source == "" {
+		_, _ = cb.Answer("😔 This track can't be sent again, please search for it.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	_, _ = cb.Answer("")
+	kb := telegram.NewKeyboard().AddRow(telegram.Button.Data(
+		"⬇️ Download again", fmt.Sprintf("spot_%s_%d", utils.EncodeURL(entry.Source), cb.SenderID),
+	))
+	_, _ = cb.Respond(fmt.Sprintf("<b>🎵 %s</b> — %s", html.EscapeString(track.Name), html.EscapeString(track.Artist)),
+		&telegram.SendOptions{ReplyMarkup: kb.Build()})
+	return nil
+}
@@ -1,8 +1,10 @@
 package src
 
 import (
+	"context"
 	"fmt"
 	"github.com/amarnathcjd/gogram/telegram"
+	"songBot/src/config"
 	"songBot/src/utils"
 	"strings"
 	"time"
@@ -10,7 +12,8 @@ import (
 
 // spotifyInlineSearch handles inline Spotify queries.
 func spotifyInlineSearch(query *telegram.InlineQuery) error {
-	q := strings.TrimSpace(query.Query)
+	// A "-f <format>" override is read again from the query when the result is sent
+	q, _, _ := parseFormatFlag(strings.TrimSpace(query.Query))
 	builder := query.Builder()
 
 	if q == "" {
@@ -19,7 +22,7 @@ func spotifyInlineSearch(query *telegram.InlineQuery) error {
 		return nil
 	}
 
-	searchData, err := utils.NewApiData(q).Search("15")
+	searchData, err := utils.Provider().Search(q, config.Cfg.InlineSearchLimit)
 	if err != nil || len(searchData.Results) == 0 {
 		builder.Article("⚠️ Error", "Failed to search Spotify.", "❌ Failed to search Spotify.")
 		_, _ = query.Answer(builder.Results())
@@ -37,22 +40,21 @@ func spotifyInlineSearch(query *telegram.InlineQuery) error {
 <b>Spotify ID:</b> <code>%s</code>`,
 			result.Name, result.Artist, result.Year, result.ID,
 		)
-		builder.Article(
-			fmt.Sprintf("%s - %s", result.Name, result.Artist),
-			result.Year,
-			msg,
-			&telegram.ArticleOptions{
-				ID: result.ID,
-				ReplyMarkup: telegram.NewKeyboard().AddRow(
-					telegram.Button.SwitchInline("🔁 Search Again", true, result.Artist),
-				).Build(),
-				Thumb: telegram.InputWebDocument{
-					URL:      result.SmallCover,
-					Size:     1500,
-					MimeType: "image/jpeg",
-				},
-			},
-		)
+		opts := &telegram.ArticleOptions{
+			ID: result.ID,
+			ReplyMarkup: telegram.NewKeyboard().AddRow(
+				telegram.Button.SwitchInline("🔁 Search Again", true, result.Artist),
+			).Build(),
+		}
+		// Local tracks of the fake provider have no web thumbnail
+		if strings.HasPrefix(result.SmallCover, "http") {
+			opts.Thumb = telegram.InputWebDocument{
+				URL:      result.SmallCover,
+				Size:     1500,
+				MimeType: "image/jpeg",
+			}
+		}
+		builder.Article(fmt.Sprintf("%s - %s", result.Name, result.Artist), result.Year, msg, opts)
 	}
 	_, _ = query.Answer(builder.Results())
 	return nil
@@ -61,40 +63,107 @@ func spotifyInlineSearch(query *telegram.InlineQuery) error {
 // spotifyInlineHandler handles inline result selection.
 func spotifyInlineHandler(update telegram.Update, client *telegram.Client) error {
 	send := update.(*telegram.UpdateBotInlineSend)
-	track, err := utils.NewApiData(send.ID).GetTrack()
+	sendCached := func(caption string, opts *telegram.SendOptions) error {
+		return clientSendEditedMessage(client, &send.MsgID, caption, opts)
+	}
+
+	_, override, _ := parseFormatFlag(send.Query)
+	format := requestFormat(send.UserID, override)
+	utils.RecordRequest(utils.StatInline, send.UserID)
+
+	idKey := utils.FormatCacheKey(utils.TrackCacheKey(botID(client), send.ID), format)
+	if entry, ok := sendCachedTrack(client, sendCached, idKey); ok {
+		recordCacheHit(utils.StatInline, entry.Platform)
+		utils.RecordDelivery(send.UserID, botID(client), entry.Track(), send.ID, entry.FileID)
+		return nil
+	}
+
+	track, err := utils.Provider().GetTrack(send.ID)
 	if err != nil {
 		_, _ = client.EditMessage(&send.MsgID, 0, "❌ Spotify song not found.")
+		utils.RecordOutcome(utils.StatInline, utils.ErrorClass(err))
+		return nil
+	}
+
+	if entry, ok := sendCachedTrack(client, sendCached, utils.FormatCacheKey(utils.TrackCacheKey(botID(client), track.TC), format)); ok {
+		recordCacheHit(utils.StatInline, track.Platform)
+		utils.StoreCachedFile(*entry, idKey)
+		utils.RecordDelivery(send.UserID, botID(client), track, send.ID, entry.FileID)
 		return nil
 	}
+	utils.RecordCache(false)
+	utils.RecordPlatform(track.Platform)
 
+	job := &utils.Job{
+		UserID:   send.UserID,
+		Title:    fmt.Sprintf("%s - %s", track.Name, track.Artist),
+		Priority: utils.PriorityHigh,
+	}
+	job.OnQueued = func(position int) {
+		_, _ = client.EditMessage(&send.MsgID, 0, queuedText(position), &telegram.SendOptions{ReplyMarkup: cancelMarkup(job.ID())})
+	}
+	job.Run = func(ctx context.Context) error {
+		return downloadTrackInline(ctx, client, send, track, format, cancelMarkup(job.ID()),
+			idKey, utils.FormatCacheKey(utils.TrackCacheKey(botID(client), track.TC), format))
+	}
+
+	runQueued(utils.StatInline, job, func(text string) { _, _ = client.EditMessage(&send.MsgID, 0, text) })
+	return nil
+}
+
+// downloadTrackInline downloads a track for a chosen inline result and replaces the inline message with it,
+// caching the upload under keys. A failure is reported in the message and returned.
+func downloadTrackInline(ctx context.Context, client *telegram.Client, send *telegram.UpdateBotInlineSend, track *utils.TrackInfo, format utils.AudioFormat, cancel telegram.ReplyMarkup, keys ...string) error {
+	_, _ = client.EditMessage(&send.MsgID, 0, "⏬ Downloading the song...", &telegram.SendOptions{ReplyMarkup: cancel})
 	dl, err := utils.NewDownload(*track)
 	if err != nil {
 		client.Logger.Warn("Invalid download:", err)
 		_, _ = client.EditMessage(&send.MsgID, 0, "⚠️ Failed to download the song."+err.Error())
-		return nil
+		return err
 	}
 
-	audioFile, thumb, err := dl.Process()
-	if err != nil || audioFile == "" {
+	dl.Format = format
+	dl.OnProgress = downloadProgress(func(text string) {
+		_, _ = client.EditMessage(&send.MsgID, 0, text, &telegram.SendOptions{ReplyMarkup: cancel})
+	})
+
+	audioFile, thumb, err := dl.Process(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil && audioFile == "" {
+		err = errMissingAudio
+	}
+	if err != nil {
 		client.Logger.Warn("Process failed:", err)
 		_, _ = client.EditMessage(&send.MsgID, 0, "⚠️ Failed to download the song.")
-		return nil
+		return err
 	}
 
 	if !fileExists(audioFile) {
 		client.Logger.Warn("[Inline] Audio file does not exist:", audioFile)
 		_, _ = client.EditMessage(&send.MsgID, 0, "❌ Audio file missing.")
-		return nil
+		return errMissingAudio
 	}
 
 	progress := telegram.NewProgressManager(3).SetInlineMessage(client, &send.MsgID)
 	caption := buildTrackCaption(track)
 	options := prepareTrackMessageOptions(audioFile, thumb, track, progress)
 
-	time.Sleep(500 * time.Millisecond)
+	// Editing an inline message returns no message to read the file_id from, so the file is uploaded
+	// to Telegram first and the inline message is edited with the resulting document
+	started := time.Now()
+	media, fileID, err := uploadInlineMedia(client, audioFile, thumb, &options)
+	if err != nil {
+		client.Logger.Warn("Upload failed:", err)
+		_, _ = client.EditMessage(&send.MsgID, 0, "❌ Failed to send the song."+err.Error())
+		return err
+	}
+	options.Media = media
+
 	err = clientSendEditedMessage(client, &send.MsgID, caption, &options)
-	if err != nil && strings.Contains(err.Error(), "MEDIA_EMPTY") {
-		client.Logger.Warn("Retrying due to MEDIA_EMPTY...")
+	if utils.IsStaleFileError(err) {
+		client.Logger.Warn("Telegram refused the upload, sending again:", err)
 		time.Sleep(1 * time.Second)
 		err = clientSendEditedMessage(client, &send.MsgID, caption, &options)
 	}
@@ -102,6 +171,31 @@ func spotifyInlineHandler(update telegram.Update, client *telegram.Client) error
 	if err != nil {
 		client.Logger.Warn("Edit failed:", err)
 		_, _ = client.EditMessage(&send.MsgID, 0, "❌ Failed to send the song."+err.Error())
+		return err
+	}
+
+	utils.ObserveDuration(utils.PhaseUpload, time.Since(started))
+	if fileID != "" {
+		utils.StoreCachedFile(utils.NewCachedFile(track, fileID, thumb), keys...)
+	}
+	utils.RecordDelivery(send.UserID, botID(client), track, send.ID, fileID)
+	return nil
+}
+
+// uploadInlineMedia uploads an audio file as a document of the bot and returns it with its file_id.
+// A file Telegram would not show as audio is left for the edit to upload as it is, and not cached.
+func uploadInlineMedia(client *telegram.Client, path string, thumb []byte, opts *telegram.SendOptions) (any, string, error) {
+	if opts.ForceDocument {
+		return path, "", nil
+	}
+
+	doc, err := uploadTrackMedia(client, path, thumb, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	media, err := client.MessagesUploadMedia("", &telegram.InputPeerSelf{}, doc)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to upload media: %w", err)
 	}
-	return err
+	return media, telegram.PackBotFileID(media), nil
 }
@@ -1,6 +1,7 @@
 package src
 
 import (
+	"songBot/src/config"
 	"songBot/src/utils"
 
 	"github.com/amarnathcjd/gogram/telegram"
@@ -23,9 +24,14 @@ func filterURLChat(m *telegram.NewMessage) bool {
 	return m.IsPrivate()
 }
 
-// FilterOwner allows only bot owner access to sensitive commands
+// FilterOwner allows only bot owners access to sensitive commands
 func FilterOwner(m *telegram.NewMessage) bool {
-	return m.SenderID() == 5938660179
+	return config.Cfg.IsOwner(m.SenderID())
+}
+
+// FilterSudo allows bot owners and sudo users access to moderation commands
+func FilterSudo(m *telegram.NewMessage) bool {
+	return config.Cfg.IsSudo(m.SenderID())
 }
 
 // InitFunc initializes the bot and registers all command, message, and callback handlers
@@ -34,22 +40,48 @@ func InitFunc(c *telegram.Client) {
 	// Public commands
 	c.On("command:start", startHandle)
 	c.On("command:ping", pingHandle)
-	c.On("command:spotify", spotifySearchSong)
+	c.On("command:spotify", limitMessage(searchBudget, spotifySearchSong))
 	c.On("command:privacy", privacyHandle)
-	c.On("command:playlist", zipHandle)
+	c.On("command:playlist", limitMessage(playlistBudget, zipHandle))
+	c.On("command:format", formatHandle)
+	c.On("command:lyrics", limitMessage(searchBudget, lyricsHandle))
+	c.On("command:history", historyHandle)
+	c.On("command:favorites", favoritesHandle)
 
 	// Inline query and inline result handler
-	c.On(telegram.OnInline, spotifyInlineSearch)
-	c.AddRawHandler(&telegram.UpdateBotInlineSend{}, spotifyInlineHandler)
+	c.On(telegram.OnInline, limitInline(inlineBudget, spotifyInlineSearch))
+	c.AddRawHandler(&telegram.UpdateBotInlineSend{}, limitInlineSend(trackBudget, spotifyInlineHandler))
 
 	// Spotify inline button callback
-	c.On("callback:spot_(.*)_(.*)", spotifyHandlerCallback)
+	c.On("callback:spot_(.*)_(.*)", limitCallback(trackBudget, spotifyHandlerCallback))
+	c.On("callback:page_(.*)", pageCallback)
+	c.On("callback:zipall_(.*)", limitCallback(playlistBudget, zipAllCallback))
+	c.On("callback:cancel_(.*)", cancelJobCallback)
+	c.On("callback:fmt_(.*)", formatCallback)
+	c.On("callback:lyrics_(.*)", lyricsCallback)
+	c.On("callback:histnav_(.*)", historyPageCallback)
+	c.On("callback:histsend_(.*)", historySendCallback)
+	c.On("callback:fav_(.*)", favoriteCallback)
+	c.On("callback:favnav_(.*)", favoritesPageCallback)
+	c.On("callback:favsend_(.*)", favoriteSendCallback)
+	c.On("callback:favzip_(.*)", limitCallback(playlistBudget, favoritesZipCallback))
+	c.On("callback:favexport_(.*)", favoritesExportCallback)
+
+	// Buttons under tracks delivered in inline messages
+	c.On("inlinecallback:fav_(.*)", inlineFavoriteCallback)
+	c.On("inlinecallback:lyrics_(.*)", inlineLyricsCallback)
 
 	// Owner-only commands
 	c.On("command:ul", uploadHandle, telegram.FilterFunc(FilterOwner))
 	c.On("command:dl", downloadHandle, telegram.FilterFunc(FilterOwner))
+	c.On("command:bots", botsHandle, telegram.FilterFunc(FilterSudo))
+	c.On("command:queue", queueHandle, telegram.FilterFunc(FilterOwner))
+	c.On("command:config", configHandle, telegram.FilterFunc(FilterOwner))
+	c.On("command:stats", statsHandle, telegram.FilterFunc(FilterOwner))
+	c.On("command:disk", diskHandle, telegram.FilterFunc(FilterOwner))
+	c.On("command:restart", restartHandle, telegram.FilterFunc(FilterOwner))
 
 	// Fallback message handler for plain URLs or private messages
-	c.On("message:*", spotifySearchSong, telegram.FilterFunc(filterURLChat))
+	c.On("message:*", limitMessage(searchBudget, spotifySearchSong), telegram.FilterFunc(filterURLChat))
 
 }
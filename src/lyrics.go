@@ -0,0 +1,140 @@
+package src
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"songBot/src/config"
+	"songBot/src/utils"
+	"strings"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// lyricsPageSize keeps a lyrics page, with its header and HTML escapes, below Telegram's 4096 characters
+const lyricsPageSize = 3500
+
+// lyricsHandle finds the lyrics of a song by name or URL: /lyrics <query|url>
+func lyricsHandle(m *telegram.NewMessage) error {
+	query := strings.TrimSpace(m.Args())
+	if query == "" {
+		_, err := m.Reply("❗ Please provide a song name or Spotify URL.\n\nExample: <code>/lyrics Numb Linkin Park</code>")
+		return err
+	}
+
+	tracks, _, err := utils.Provider().Resolve(query, 1)
+	if err != nil || tracks == nil || len(tracks.Results) == 0 {
+		_, _ = m.Reply("😔 No results found.")
+		return nil
+	}
+
+	track, err := utils.Provider().GetTrack(tracks.Results[0].URL)
+	if err != nil {
+		m.Client.Logger.Warn("Failed to fetch track:", err.Error())
+		_, _ = m.Reply("❌ Could not fetch track details.")
+		return nil
+	}
+
+	sendLyrics(func(text string) (*telegram.NewMessage, error) { return m.Reply(text) }, track)
+	return nil
+}
+
+// lyricsCallback sends the lyrics of a delivered track: lyrics_<tc>
+func lyricsCallback(cb *telegram.CallbackQuery) error {
+	track, ok := utils.GetLyrics(strings.TrimPrefix(cb.DataString(), "lyrics_"))
+	if !ok {
+		_, _ = cb.Answer("😔 No lyrics available for this track.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	_, _ = cb.Answer("📝 Sending the lyrics...")
+	sendLyrics(func(text string) (*telegram.NewMessage, error) { return cb.Respond(text) }, track)
+	return nil
+}
+
+// inlineLyricsCallback sends the lyrics of a track delivered in an inline message: lyrics_<tc>.
+// The bot cannot write to the chat of an inline message, so they go to the private chat with the user.
+func inlineLyricsCallback(cb *telegram.InlineCallbackQuery) error {
+	track, ok := utils.GetLyrics(strings.TrimPrefix(cb.DataString(), "lyrics_"))
+	if !ok {
+		_, _ = cb.Answer("😔 No lyrics available for this track.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	var sendErr error
+	sendLyrics(func(text string) (*telegram.NewMessage, error) {
+		msg, err := cb.Client.SendMessage(cb.SenderID, text)
+		sendErr = err
+		return msg, err
+	}, track)
+	if sendErr != nil {
+		_, _ = cb.Answer("📝 Start a private chat with me first, then tap again to get the lyrics.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	_, _ = cb.Answer("📝 The lyrics are in our private chat.")
+	return nil
+}
+
+// sendLyrics sends the lyrics of a track over as many messages as needed, plus an .lrc file when
+// they are synced. reply sends the first message, the rest follow it.
+func sendLyrics(reply func(text string) (*telegram.NewMessage, error), track *utils.TrackInfo) {
+	lyrics := utils.ParseLyrics(track.Lyrics)
+	if lyrics.Empty() {
+		_, _ = reply(fmt.Sprintf("😔 No lyrics found for <b>%s</b> — %s.", html.EscapeString(track.Name), html.EscapeString(track.Artist)))
+		return
+	}
+
+	pages := utils.SplitText(lyrics.Plain(), lyricsPageSize)
+	title := fmt.Sprintf("📝 <b>%s</b> — %s", html.EscapeString(track.Name), html.EscapeString(track.Artist))
+
+	var last *telegram.NewMessage
+	for i, page := range pages {
+		text := title
+		if len(pages) > 1 {
+			text += fmt.Sprintf(" (%d/%d)", i+1, len(pages))
+		}
+		text += "\n\n" + html.EscapeString(page)
+
+		var err error
+		if last == nil {
+			last, err = reply(text)
+		} else {
+			last, err = last.Respond(text)
+		}
+		if err != nil {
+			log.Printf("[Lyrics] ❌ Failed to send lyrics: %v", err)
+			return
+		}
+	}
+
+	if lyrics.Synced {
+		if err := sendLRC(last, track, lyrics); err != nil {
+			log.Printf("[Lyrics] ❌ Failed to send LRC file: %v", err)
+		}
+	}
+}
+
+// sendLRC attaches synced lyrics as an .lrc file below msg
+func sendLRC(msg *telegram.NewMessage, track *utils.TrackInfo, lyrics utils.Lyrics) error {
+	file, err := os.CreateTemp(config.Cfg.DownloadPath, "lyrics_*.lrc")
+	if err != nil {
+		return fmt.Errorf("failed to create LRC file: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	_, err = file.WriteString(lyrics.LRC(track))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write LRC file: %w", err)
+	}
+
+	_, err = msg.RespondMedia(file.Name(), telegram.MediaOptions{
+		FileName:      utils.SanitizeFilename(track.Artist+" - "+track.Name) + ".lrc",
+		Caption:       "⏱ Synced lyrics",
+		ForceDocument: true,
+	})
+	return err
+}
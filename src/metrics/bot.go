@@ -0,0 +1,39 @@
+package metrics
+
+import "runtime"
+
+// Metrics of the bot
+var (
+	APIRequests = NewCounter("songbot_api_requests_total",
+		"Requests made to the music API, by endpoint and HTTP status (error when no response came).",
+		"endpoint", "status")
+	APIDuration = NewHistogram("songbot_api_request_duration_seconds",
+		"Duration of music API requests, by endpoint.",
+		DurationBuckets, "endpoint")
+
+	CDNBytes = NewCounter("songbot_cdn_download_bytes_total",
+		"Bytes downloaded from the CDN, by kind (direct or spotify, which is decrypted while downloading).",
+		"kind")
+	CDNDuration = NewHistogram("songbot_cdn_download_duration_seconds",
+		"Duration of CDN downloads, by kind and result (ok or error).",
+		DurationBuckets, "kind", "result")
+
+	SubprocessDuration = NewHistogram("songbot_subprocess_duration_seconds",
+		"Duration of external commands such as ffmpeg, by command.",
+		DurationBuckets, "command")
+	SubprocessFailures = NewCounter("songbot_subprocess_failures_total",
+		"External commands that failed, by command.",
+		"command")
+
+	PhaseDuration = NewHistogram("songbot_delivery_phase_duration_seconds",
+		"Duration of the phases of a delivery (download, decrypt, transcode, upload).",
+		DurationBuckets, "phase")
+
+	FloodWaits = NewCounter("songbot_flood_waits_total",
+		"Flood waits imposed by Telegram.")
+	FloodWaitSeconds = NewCounter("songbot_flood_wait_seconds_total",
+		"Seconds spent waiting out Telegram flood waits.")
+
+	_ = NewGaugeFunc("songbot_goroutines", "Number of goroutines of the process.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+)
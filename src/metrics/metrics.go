@@ -0,0 +1,239 @@
+// Package metrics keeps counters, gauges and histograms of the bot process and serves them in the
+// Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DurationBuckets are the histogram bounds, in seconds, used for everything timed by the bot
+var DurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// collector is a metric family that can write itself
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registry   []collector
+	registryMu sync.Mutex
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// WriteText writes every registered metric in the Prometheus text format
+func WriteText(w io.Writer) error {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics to a Prometheus scrape
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteText(w)
+	})
+}
+
+// family is the name, help and label names shared by the series of a metric
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (f *family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+}
+
+// key joins label values into a map key; it panics on a wrong number of values, a programming error
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs writes `a="x",b="y"` for the values of a series key, plus extra pairs
+func (f *family) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(f.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, f.labels[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a value that only goes up, split by labels
+type Counter struct {
+	family
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: family{name, help, "counter", labels}, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+// Inc adds one to the series of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v (which must not be negative) to the series of the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+// Histogram counts observations into buckets, split by labels
+type Histogram struct {
+	family
+	bounds []float64
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // per bound, not cumulative, plus +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the given upper bounds and label names
+func NewHistogram(name, help string, bounds []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		family: family{name, help, "histogram", labels},
+		bounds: append([]float64(nil), bounds...),
+		series: map[string]*histogramSeries{},
+	}
+	sort.Float64s(h.bounds)
+	register(h)
+	return h
+}
+
+// Observe adds a value to the series of the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{counts: make([]uint64, len(h.bounds)+1)}
+		h.series[key] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), s.count)
+	}
+}
+
+// GaugeFunc is a value read when the metrics are scraped
+type GaugeFunc struct {
+	family
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is fn()
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{family: family{name: name, help: help, kind: "gauge"}, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
@@ -0,0 +1,274 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Codec of a logical stream, detected from its identification header
+type Codec int
+
+const (
+	CodecUnknown Codec = iota
+	CodecVorbis
+	CodecOpus
+)
+
+var (
+	vorbisIDMagic      = []byte("\x01vorbis")
+	vorbisCommentMagic = []byte("\x03vorbis")
+	opusIDMagic        = []byte("OpusHead")
+	opusCommentMagic   = []byte("OpusTags")
+
+	ErrUnsupportedCodec = errors.New("ogg: stream is neither Vorbis nor Opus")
+	ErrBadComment       = errors.New("ogg: malformed comment header")
+	ErrMissingHeaders   = errors.New("ogg: stream ended before all header packets")
+)
+
+// detectCodec identifies the codec from the first packet of a stream
+func detectCodec(packet []byte) Codec {
+	switch {
+	case bytes.HasPrefix(packet, vorbisIDMagic):
+		return CodecVorbis
+	case bytes.HasPrefix(packet, opusIDMagic):
+		return CodecOpus
+	default:
+		return CodecUnknown
+	}
+}
+
+// headerPackets is the number of header packets that precede the audio data
+func (c Codec) headerPackets() int {
+	if c == CodecOpus {
+		return 2
+	}
+	return 3
+}
+
+// Comments is a Vorbis comment block (also used by Opus as OpusTags)
+type Comments struct {
+	Vendor string
+	Fields []string // "KEY=value"
+}
+
+// Add appends a field; empty values are skipped
+func (c *Comments) Add(key, value string) {
+	if value == "" {
+		return
+	}
+	c.Fields = append(c.Fields, strings.ToUpper(key)+"="+value)
+}
+
+// Set replaces every field named key with a single value
+func (c *Comments) Set(key, value string) {
+	c.Delete(key)
+	c.Add(key, value)
+}
+
+// Delete removes every field named key
+func (c *Comments) Delete(key string) {
+	prefix := strings.ToUpper(key) + "="
+	fields := c.Fields[:0]
+	for _, field := range c.Fields {
+		if !strings.HasPrefix(strings.ToUpper(field), prefix) {
+			fields = append(fields, field)
+		}
+	}
+	c.Fields = fields
+}
+
+// Get returns the value of the first field named key
+func (c *Comments) Get(key string) string {
+	prefix := strings.ToUpper(key) + "="
+	for _, field := range c.Fields {
+		if strings.HasPrefix(strings.ToUpper(field), prefix) {
+			return field[len(prefix):]
+		}
+	}
+	return ""
+}
+
+// ParseComments decodes a Vorbis or Opus comment header packet
+func ParseComments(packet []byte) (*Comments, error) {
+	var body []byte
+	switch {
+	case bytes.HasPrefix(packet, vorbisCommentMagic):
+		body = packet[len(vorbisCommentMagic):]
+	case bytes.HasPrefix(packet, opusCommentMagic):
+		body = packet[len(opusCommentMagic):]
+	default:
+		return nil, ErrBadComment
+	}
+
+	r := bytes.NewReader(body)
+	readString := func() (string, error) {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", ErrBadComment
+		}
+		if int64(n) > int64(r.Len()) {
+			return "", ErrBadComment
+		}
+		buf := make([]byte, n)
+		_, _ = io.ReadFull(r, buf)
+		return string(buf), nil
+	}
+
+	vendor, err := readString()
+	if err != nil {
+		return nil, err
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, ErrBadComment
+	}
+
+	c := &Comments{Vendor: vendor}
+	for i := uint32(0); i < count; i++ {
+		field, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		c.Fields = append(c.Fields, field)
+	}
+	return c, nil
+}
+
+// packet encodes the comment header for the given codec
+func (c *Comments) packet(codec Codec) []byte {
+	var buf bytes.Buffer
+	if codec == CodecOpus {
+		buf.Write(opusCommentMagic)
+	} else {
+		buf.Write(vorbisCommentMagic)
+	}
+
+	writeString := func(s string) {
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+
+	writeString(c.Vendor)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(c.Fields)))
+	for _, field := range c.Fields {
+		writeString(field)
+	}
+
+	if codec != CodecOpus {
+		buf.WriteByte(1) // framing bit
+	}
+	return buf.Bytes()
+}
+
+// UpdateComments rewrites the comment header of the Ogg file at path.
+// update receives the current comments and may change them; audio pages are copied unchanged
+// apart from their sequence numbers. The file is replaced atomically.
+func UpdateComments(path string, update func(c *Comments)) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("ogg: %w", err)
+	}
+	defer func(in *os.File) {
+		_ = in.Close()
+	}(in)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("ogg: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if err := rewriteComments(tmp, in, update); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("ogg: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// rewriteComments copies an Ogg stream from src to dst with a new comment header
+func rewriteComments(dst io.Writer, src io.Reader, update func(c *Comments)) error {
+	reader := NewReader(src)
+
+	var (
+		splitter packetSplitter
+		headers  [][]byte
+		codec    Codec
+		serial   uint32
+	)
+
+	// Collect the header packets; audio data always starts on a fresh page
+	for codec == CodecUnknown || len(headers) < codec.headerPackets() {
+		page, err := reader.ReadPage()
+		if errors.Is(err, io.EOF) {
+			return ErrMissingHeaders
+		}
+		if err != nil {
+			return err
+		}
+		if len(headers) == 0 {
+			serial = page.Serial
+		}
+		if page.Serial != serial {
+			continue
+		}
+
+		headers = append(headers, splitter.push(page)...)
+		if codec == CodecUnknown && len(headers) > 0 {
+			if codec = detectCodec(headers[0]); codec == CodecUnknown {
+				return ErrUnsupportedCodec
+			}
+		}
+	}
+	if splitter.pending() || len(headers) > codec.headerPackets() {
+		return fmt.Errorf("ogg: audio data shares a page with the headers")
+	}
+
+	comments, err := ParseComments(headers[1])
+	if err != nil {
+		return err
+	}
+	update(comments)
+	headers[1] = comments.packet(codec)
+
+	w := NewWriter(dst, serial)
+	if err := w.WritePacket(headers[0], 0); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, header := range headers[1:] {
+		if err := w.WritePacket(header, 0); err != nil {
+			return err
+		}
+	}
+
+	for {
+		page, err := reader.ReadPage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if page.Serial != serial {
+			continue
+		}
+		if err := w.WritePage(page); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
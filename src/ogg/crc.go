@@ -0,0 +1,29 @@
+package ogg
+
+// crcTable is the lookup table of the Ogg CRC-32 (polynomial 0x04c11db7, no reflection)
+var crcTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// checksum computes the CRC of an encoded page whose checksum field is ignored (treated as zero)
+func checksum(page []byte) uint32 {
+	var crc uint32
+	for i, b := range page {
+		if i >= 22 && i < 26 {
+			b = 0
+		}
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
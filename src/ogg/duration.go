@@ -0,0 +1,66 @@
+package ogg
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// opusGranuleRate is the rate of Opus granule positions, whatever the input sample rate was
+const opusGranuleRate = 48000
+
+// StreamInfo describes the first logical stream of an Ogg file
+type StreamInfo struct {
+	Codec    Codec
+	Duration time.Duration
+}
+
+// Probe reads an Ogg stream to its end and returns its codec and playing time,
+// computed from the granule position of the last page of the first logical stream
+func Probe(r io.Reader) (StreamInfo, error) {
+	reader := NewReader(r)
+	first, err := reader.ReadPage()
+	if err != nil {
+		return StreamInfo{}, err
+	}
+
+	// The first page holds exactly the identification header
+	info := StreamInfo{Codec: detectCodec(first.Body)}
+	var rate, preSkip int64
+	switch info.Codec {
+	case CodecVorbis:
+		if len(first.Body) < 16 {
+			return info, errors.New("ogg: short Vorbis identification header")
+		}
+		rate = int64(binary.LittleEndian.Uint32(first.Body[12:16]))
+	case CodecOpus:
+		if len(first.Body) < 12 {
+			return info, errors.New("ogg: short Opus identification header")
+		}
+		rate = opusGranuleRate
+		preSkip = int64(binary.LittleEndian.Uint16(first.Body[10:12]))
+	default:
+		return info, ErrUnsupportedCodec
+	}
+	if rate == 0 {
+		return info, errors.New("ogg: zero sample rate")
+	}
+
+	last := first.Granule
+	for {
+		// A damaged tail still leaves the duration up to the last good page
+		page, err := reader.ReadPage()
+		if err != nil {
+			break
+		}
+		if page.Serial == first.Serial && page.Granule > 0 {
+			last = page.Granule
+		}
+	}
+
+	if samples := last - preSkip; samples > 0 {
+		info.Duration = time.Duration(samples) * time.Second / time.Duration(rate)
+	}
+	return info, nil
+}
@@ -0,0 +1,172 @@
+// Package ogg reads and writes Ogg pages and the header packets of Vorbis and Opus streams.
+package ogg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Header type flags of a page
+const (
+	FlagContinued = 0x01
+	FlagBOS       = 0x02
+	FlagEOS       = 0x04
+)
+
+const (
+	headerSize     = 27
+	maxSegments    = 255
+	maxSegmentSize = 255
+)
+
+var (
+	capturePattern = []byte("OggS")
+
+	ErrBadCapture  = errors.New("ogg: missing capture pattern")
+	ErrBadVersion  = errors.New("ogg: unsupported stream structure version")
+	ErrBadChecksum = errors.New("ogg: page checksum mismatch")
+)
+
+// Page is a single Ogg page
+type Page struct {
+	Type     byte
+	Granule  int64
+	Serial   uint32
+	Sequence uint32
+	Segments []byte // lacing values
+	Body     []byte
+}
+
+// Continued reports whether the first packet on the page continues from the previous page
+func (p *Page) Continued() bool {
+	return p.Type&FlagContinued != 0
+}
+
+// Bytes encodes the page, computing its checksum
+func (p *Page) Bytes() []byte {
+	buf := make([]byte, headerSize+len(p.Segments)+len(p.Body))
+	copy(buf, capturePattern)
+	buf[4] = 0
+	buf[5] = p.Type
+	binary.LittleEndian.PutUint64(buf[6:], uint64(p.Granule))
+	binary.LittleEndian.PutUint32(buf[14:], p.Serial)
+	binary.LittleEndian.PutUint32(buf[18:], p.Sequence)
+	buf[26] = byte(len(p.Segments))
+	copy(buf[headerSize:], p.Segments)
+	copy(buf[headerSize+len(p.Segments):], p.Body)
+
+	binary.LittleEndian.PutUint32(buf[22:], checksum(buf))
+	return buf
+}
+
+// Reader reads consecutive pages from a stream
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a page reader on top of r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64<<10)}
+}
+
+// ReadPage reads and verifies the next page. It returns io.EOF at the end of the stream.
+func (r *Reader) ReadPage() (*Page, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("ogg: truncated page header: %w", err)
+		}
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:4], capturePattern) {
+		return nil, ErrBadCapture
+	}
+	if header[4] != 0 {
+		return nil, ErrBadVersion
+	}
+
+	page, err := r.readRest(header)
+	if err != nil {
+		return nil, err
+	}
+
+	want := binary.LittleEndian.Uint32(header[22:])
+	if got := binary.LittleEndian.Uint32(page.Bytes()[22:]); got != want {
+		return nil, ErrBadChecksum
+	}
+	return page, nil
+}
+
+// readRest reads the lacing table and body that follow a page header
+func (r *Reader) readRest(header [headerSize]byte) (*Page, error) {
+	page := &Page{
+		Type:     header[5],
+		Granule:  int64(binary.LittleEndian.Uint64(header[6:])),
+		Serial:   binary.LittleEndian.Uint32(header[14:]),
+		Sequence: binary.LittleEndian.Uint32(header[18:]),
+		Segments: make([]byte, header[26]),
+	}
+
+	if _, err := io.ReadFull(r.r, page.Segments); err != nil {
+		return nil, fmt.Errorf("ogg: truncated lacing table: %w", err)
+	}
+
+	size := 0
+	for _, lace := range page.Segments {
+		size += int(lace)
+	}
+
+	page.Body = make([]byte, size)
+	if _, err := io.ReadFull(r.r, page.Body); err != nil {
+		return nil, fmt.Errorf("ogg: truncated page body: %w", err)
+	}
+	return page, nil
+}
+
+// packetSplitter reassembles packets from the pages of a single logical stream
+type packetSplitter struct {
+	partial []byte
+	skip    bool // drop the continuation of a packet whose start was lost
+}
+
+// push feeds a page and returns every packet completed on it
+func (s *packetSplitter) push(p *Page) [][]byte {
+	if !p.Continued() {
+		s.partial = s.partial[:0]
+		s.skip = false
+	}
+
+	var packets [][]byte
+	offset := 0
+	for _, lace := range p.Segments {
+		segment := p.Body[offset : offset+int(lace)]
+		offset += int(lace)
+		if s.skip {
+			s.skip = lace == maxSegmentSize
+			continue
+		}
+
+		s.partial = append(s.partial, segment...)
+		if lace < maxSegmentSize {
+			packets = append(packets, append([]byte(nil), s.partial...))
+			s.partial = s.partial[:0]
+		}
+	}
+	return packets
+}
+
+// pending reports whether a packet is still incomplete at the end of the last page
+func (s *packetSplitter) pending() bool {
+	return len(s.partial) > 0
+}
+
+// discard drops the incomplete packet along with its continuation on the next page
+func (s *packetSplitter) discard() {
+	s.partial = s.partial[:0]
+	s.skip = true
+}
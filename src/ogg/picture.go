@@ -0,0 +1,162 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// PictureFrontCover is the FLAC picture type of an album front cover
+const PictureFrontCover = 3
+
+var (
+	jpegMagic = []byte{0xFF, 0xD8, 0xFF}
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+
+	ErrUnknownImage = errors.New("ogg: cover is neither JPEG nor PNG")
+)
+
+// Picture is a FLAC METADATA_BLOCK_PICTURE, the way Vorbis comments carry cover art
+type Picture struct {
+	Type        uint32
+	MIME        string
+	Description string
+	Width       uint32
+	Height      uint32
+	Depth       uint32 // bits per pixel
+	Colors      uint32 // palette size for indexed images, 0 otherwise
+	Data        []byte
+}
+
+// NewPicture builds a front cover picture, reading the dimensions from the JPEG or PNG data
+func NewPicture(data []byte) (*Picture, error) {
+	pic := &Picture{Type: PictureFrontCover, Description: "Cover Artwork", Data: data}
+
+	var err error
+	switch {
+	case bytes.HasPrefix(data, jpegMagic):
+		pic.MIME = "image/jpeg"
+		err = pic.readJPEG()
+	case bytes.HasPrefix(data, pngMagic):
+		pic.MIME = "image/png"
+		err = pic.readPNG()
+	default:
+		err = ErrUnknownImage
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return pic, nil
+}
+
+// readJPEG takes the dimensions from the first start-of-frame segment
+func (p *Picture) readJPEG() error {
+	data := p.Data
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return errors.New("ogg: malformed JPEG marker")
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			i += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		isSOF := marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC
+		if isSOF {
+			if i+10 > len(data) {
+				break
+			}
+			precision := uint32(data[i+4])
+			p.Height = uint32(binary.BigEndian.Uint16(data[i+5:]))
+			p.Width = uint32(binary.BigEndian.Uint16(data[i+7:]))
+			p.Depth = precision * uint32(data[i+9])
+			return nil
+		}
+		i += 2 + length
+	}
+	return errors.New("ogg: JPEG has no frame header")
+}
+
+// readPNG takes the dimensions from IHDR and the palette size from PLTE
+func (p *Picture) readPNG() error {
+	data := p.Data[len(pngMagic):]
+	for len(data) >= 12 {
+		length := int(binary.BigEndian.Uint32(data))
+		kind := string(data[4:8])
+		if 12+length > len(data) {
+			break
+		}
+		chunk := data[8 : 8+length]
+
+		switch kind {
+		case "IHDR":
+			if length < 13 {
+				return errors.New("ogg: malformed PNG header")
+			}
+			p.Width = binary.BigEndian.Uint32(chunk)
+			p.Height = binary.BigEndian.Uint32(chunk[4:])
+			bitDepth := uint32(chunk[8])
+			switch chunk[9] { // colour type
+			case 0:
+				p.Depth = bitDepth
+			case 2:
+				p.Depth = bitDepth * 3
+			case 3:
+				// Palette entries are always 8-bit RGB
+				p.Depth = 24
+			case 4:
+				p.Depth = bitDepth * 2
+			case 6:
+				p.Depth = bitDepth * 4
+			}
+			if chunk[9] != 3 {
+				return nil
+			}
+		case "PLTE":
+			p.Colors = uint32(length / 3)
+			return nil
+		case "IDAT", "IEND":
+			return nil
+		}
+		data = data[12+length:]
+	}
+
+	if p.Width == 0 {
+		return errors.New("ogg: PNG has no header chunk")
+	}
+	return nil
+}
+
+// Bytes encodes the picture as a FLAC picture block (without the metadata block header)
+func (p *Picture) Bytes() []byte {
+	var buf bytes.Buffer
+	put := func(v uint32) {
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+
+	put(p.Type)
+	put(uint32(len(p.MIME)))
+	buf.WriteString(p.MIME)
+	put(uint32(len(p.Description)))
+	buf.WriteString(p.Description)
+	put(p.Width)
+	put(p.Height)
+	put(p.Depth)
+	put(p.Colors)
+	put(uint32(len(p.Data)))
+	buf.Write(p.Data)
+	return buf.Bytes()
+}
+
+// CommentValue returns the base64 value of a METADATA_BLOCK_PICTURE comment field
+func (p *Picture) CommentValue() string {
+	return base64.StdEncoding.EncodeToString(p.Bytes())
+}
@@ -0,0 +1,245 @@
+package ogg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// identificationPageSize is the size of the first page of every Vorbis stream:
+// a 27 byte header, one lacing value and the 30 byte identification packet
+const identificationPageSize = headerSize + 1 + identificationSize
+
+// ErrUnrecoverable is returned when the input does not contain enough of a Vorbis stream to rebuild it
+var ErrUnrecoverable = errors.New("ogg: stream is not recoverable")
+
+// RepairStats describes what Repair had to fix
+type RepairStats struct {
+	RebuiltIdentification bool
+	SkippedBytes          int64
+	Pages                 int
+	Packets               int
+	Samples               int64
+}
+
+// Repair rewrites a damaged Vorbis stream, such as a decrypted Spotify file, into a valid Ogg file.
+//
+// The identification header is kept when it is intact and rebuilt from params otherwise; the
+// header pages are read without trusting their capture pattern, flags, granule or checksum,
+// and the serial number is taken from the comment page.
+// Audio packets are re-paginated with fresh sequence numbers, checksums and granule positions
+// computed from the block sizes. update, when not nil, may change the comment header.
+func Repair(dst io.Writer, src io.Reader, params Params, update func(c *Comments)) (*RepairStats, error) {
+	r := &lenientReader{r: bufio.NewReaderSize(src, 64<<10)}
+	stats := &RepairStats{}
+
+	var first [identificationPageSize]byte
+	if _, err := io.ReadFull(r.r, first[:]); err != nil {
+		return stats, fmt.Errorf("%w: %v", ErrUnrecoverable, err)
+	}
+
+	var (
+		splitter packetSplitter
+		packets  [][]byte
+		serial   uint32
+	)
+
+	intact := bytes.Equal(first[:4], capturePattern) && first[26] == 1 && first[27] == identificationSize
+	if p, err := parseIdentification(first[headerSize+1:]); intact && err == nil {
+		params = p
+		serial = binary.LittleEndian.Uint32(first[14:])
+	} else {
+		stats.RebuiltIdentification = true
+	}
+	if !params.valid() {
+		return stats, fmt.Errorf("%w: invalid stream parameters", ErrUnrecoverable)
+	}
+
+	// The comment and setup headers start on the second page
+	second, err := r.readHeaderPage()
+	if err != nil {
+		return stats, fmt.Errorf("%w: %v", ErrUnrecoverable, err)
+	}
+	if stats.RebuiltIdentification {
+		serial = second.Serial
+	}
+	second.Type = 0
+	packets = splitter.push(second)
+	lastSequence := second.Sequence
+
+	for len(packets) < 2 {
+		page, err := r.readPage()
+		if err != nil {
+			return stats, fmt.Errorf("%w: headers incomplete: %v", ErrUnrecoverable, err)
+		}
+		packets = append(packets, splitter.push(page)...)
+		lastSequence = page.Sequence
+	}
+
+	comment, setup := packets[0], packets[1]
+	packets = packets[2:]
+	if bytes.HasPrefix(comment, vorbisSetupMagic) {
+		// No comment header at all, the setup header came right away
+		packets = append([][]byte{setup}, packets...)
+		comment, setup = nil, comment
+	}
+
+	comments, err := ParseComments(comment)
+	if err != nil {
+		comments = &Comments{}
+	}
+	if update != nil {
+		update(comments)
+	}
+
+	timer, err := newPacketTimer(params, setup)
+	if err != nil {
+		return stats, fmt.Errorf("%w: %v", ErrUnrecoverable, err)
+	}
+
+	w := NewWriter(dst, serial)
+	if err := w.WritePacket(params.identification(), 0); err != nil {
+		return stats, err
+	}
+	if err := w.Flush(); err != nil {
+		return stats, err
+	}
+	if err := w.WritePacket(comments.packet(CodecVorbis), 0); err != nil {
+		return stats, err
+	}
+	if err := w.WritePacket(setup, 0); err != nil {
+		return stats, err
+	}
+	if err := w.Flush(); err != nil {
+		return stats, err
+	}
+
+	writeAudio := func(packets [][]byte) error {
+		for _, packet := range packets {
+			if len(packet) == 0 {
+				continue
+			}
+			stats.Samples += timer.samples(packet)
+			stats.Packets++
+			if err := w.WritePacket(packet, stats.Samples); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Audio packets that shared a page with the setup header are moved to their own page
+	if err := writeAudio(packets); err != nil {
+		return stats, err
+	}
+
+	for {
+		page, err := r.readPage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		if r.resynced && page.Sequence != lastSequence+1 {
+			// Pages were lost in the garbage, so was the packet that spanned them
+			splitter.discard()
+		}
+		r.resynced = false
+		lastSequence = page.Sequence
+
+		if err := writeAudio(splitter.push(page)); err != nil {
+			return stats, err
+		}
+	}
+
+	if stats.Packets == 0 {
+		return stats, fmt.Errorf("%w: no audio packets", ErrUnrecoverable)
+	}
+
+	stats.SkippedBytes = r.skipped
+	if err := w.Close(); err != nil {
+		return stats, err
+	}
+	stats.Pages = int(w.sequence)
+	return stats, nil
+}
+
+// RepairToFile repairs the stream read from src into dstPath, which only appears once it is complete
+func RepairToFile(dstPath string, src io.Reader, params Params, update func(c *Comments)) (*RepairStats, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), filepath.Base(dstPath)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("ogg: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	bw := bufio.NewWriterSize(tmp, 64<<10)
+	stats, err := Repair(bw, src, params, update)
+	if err != nil {
+		return stats, err
+	}
+	if err := bw.Flush(); err != nil {
+		return stats, fmt.Errorf("ogg: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return stats, fmt.Errorf("ogg: %w", err)
+	}
+	return stats, os.Rename(tmp.Name(), dstPath)
+}
+
+// lenientReader reads the pages of a damaged stream: checksums are ignored and garbage between
+// pages is skipped until the next capture pattern
+type lenientReader struct {
+	r        *bufio.Reader
+	skipped  int64
+	resynced bool
+}
+
+// readHeaderPage reads a page without looking at its capture pattern, version, flags or checksum
+func (lr *lenientReader) readHeaderPage() (*Page, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(lr.r, header[:]); err != nil {
+		return nil, fmt.Errorf("truncated page header: %w", err)
+	}
+	return (&Reader{r: lr.r}).readRest(header)
+}
+
+// readPage reads the next page that starts with a capture pattern
+func (lr *lenientReader) readPage() (*Page, error) {
+	for {
+		peek, err := lr.r.Peek(len(capturePattern))
+		if len(peek) < len(capturePattern) {
+			if err == nil || errors.Is(err, io.EOF) || errors.Is(err, bufio.ErrBufferFull) {
+				// Trailing bytes too short to hold a page
+				n, _ := lr.r.Discard(len(peek))
+				lr.skipped += int64(n)
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		if bytes.Equal(peek, capturePattern) {
+			break
+		}
+		_, _ = lr.r.Discard(1)
+		lr.skipped++
+		lr.resynced = true
+	}
+
+	page, err := lr.readHeaderPage()
+	if err != nil {
+		// A truncated last page is dropped
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return page, nil
+}
@@ -0,0 +1,335 @@
+package ogg
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the fixtures in testdata")
+
+// fixtureSerial is the serial number of the fixture streams
+const fixtureSerial = 0x5eed
+
+// fixture reads a file of testdata, writing it with build first when -update is set
+func fixture(t *testing.T, name string, build func() []byte) []byte {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, build(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading fixture (run with -update to create it): %v", err)
+	}
+	return data
+}
+
+// bitWriter packs fields LSB-first, like the Vorbis bitstream
+type bitWriter struct {
+	buf  []byte
+	bits int
+}
+
+func (b *bitWriter) write(v uint32, n int) {
+	for i := 0; i < n; i++ {
+		if b.bits%8 == 0 {
+			b.buf = append(b.buf, 0)
+		}
+		b.buf[len(b.buf)-1] |= byte(v>>i&1) << (b.bits % 8)
+		b.bits++
+	}
+}
+
+// testSetup is a setup header whose codebooks are filler and whose two modes use the short
+// and the long block
+func testSetup() []byte {
+	b := &bitWriter{}
+	for i := 0; i < 200; i++ {
+		b.write(0xFF, 8)
+	}
+	b.write(1, 6) // mode count - 1
+	for blockflag := uint32(0); blockflag < 2; blockflag++ {
+		b.write(blockflag, 1)
+		b.write(0, 16)        // window type
+		b.write(0, 16)        // transform type
+		b.write(blockflag, 8) // mapping
+	}
+	b.write(1, 1) // framing
+	return append(append([]byte(nil), vorbisSetupMagic...), b.buf...)
+}
+
+// testSamples computes the samples each audio packet of a testSetup stream decodes to
+func testSamples(packets [][]byte) []int64 {
+	samples := make([]int64, len(packets))
+	var previous int64
+	for i, p := range packets {
+		current := int64(1) << SpotifyParams.Blocksize0
+		if p[0]&2 != 0 {
+			current = 1 << SpotifyParams.Blocksize1
+		}
+		if previous > 0 {
+			samples[i] = (previous + current) / 4
+		}
+		previous = current
+	}
+	return samples
+}
+
+// testAudio returns audio packets of random sizes and modes, some of them spanning pages
+func testAudio() [][]byte {
+	r := rand.New(rand.NewSource(1))
+	packets := make([][]byte, 120)
+	for i := range packets {
+		p := make([]byte, 1+r.Intn(1500))
+		r.Read(p)
+		p[0] &^= 1 // audio packet
+		packets[i] = p
+	}
+	return packets
+}
+
+// buildValid encodes a well-formed stream of the testAudio packets
+func buildValid() []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, fixtureSerial)
+	_ = w.WritePacket(SpotifyParams.identification(), 0)
+	_ = w.Flush()
+
+	comments := &Comments{Vendor: "songBot test"}
+	comments.Add("TITLE", "Fixture")
+	_ = w.WritePacket(comments.packet(CodecVorbis), 0)
+	_ = w.WritePacket(testSetup(), 0)
+	_ = w.Flush()
+
+	audio := testAudio()
+	var granule int64
+	for i, n := range testSamples(audio) {
+		granule += n
+		_ = w.WritePacket(audio[i], granule)
+	}
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+// buildDamaged damages the valid stream the way a decrypted Spotify file and a flaky download do:
+// the identification page is scrambled, 7 bytes of garbage precede the fourth page, the sixth
+// page is overwritten and 3 bytes trail the stream
+func buildDamaged() []byte {
+	valid := buildValid()
+	var offsets []int
+	for i := 0; i < len(valid); {
+		offsets = append(offsets, i)
+		next := bytes.Index(valid[i+1:], capturePattern)
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	damaged := append([]byte(nil), valid...)
+	r := rand.New(rand.NewSource(2))
+	r.Read(damaged[:identificationPageSize])
+	copy(damaged[offsets[5]:offsets[6]], bytes.Repeat([]byte{0xAA}, offsets[6]-offsets[5]))
+
+	out := append([]byte(nil), damaged[:offsets[3]]...)
+	out = append(out, "garbage"...)
+	out = append(out, damaged[offsets[3]:]...)
+	return append(out, 1, 2, 3)
+}
+
+// decoded is a repaired stream read back with checksum verification
+type decoded struct {
+	pages   []*Page
+	packets [][]byte
+}
+
+func decode(t *testing.T, data []byte) decoded {
+	t.Helper()
+	var (
+		d        decoded
+		splitter packetSplitter
+	)
+	r := NewReader(bytes.NewReader(data))
+	for {
+		page, err := r.ReadPage()
+		if errors.Is(err, io.EOF) {
+			return d
+		}
+		if err != nil {
+			t.Fatalf("page %d: %v", len(d.pages), err)
+		}
+		d.pages = append(d.pages, page)
+		d.packets = append(d.packets, splitter.push(page)...)
+	}
+}
+
+// checkStructure verifies the page flags, numbering and granule positions of a repaired stream
+func checkStructure(t *testing.T, d decoded) {
+	t.Helper()
+	if len(d.pages) < 3 || len(d.packets) < 4 {
+		t.Fatalf("got %d pages and %d packets", len(d.pages), len(d.packets))
+	}
+	for i, page := range d.pages {
+		if page.Serial != fixtureSerial {
+			t.Errorf("page %d: serial %#x, want %#x", i, page.Serial, fixtureSerial)
+		}
+		if page.Sequence != uint32(i) {
+			t.Errorf("page %d: sequence %d", i, page.Sequence)
+		}
+		if bos := page.Type&FlagBOS != 0; bos != (i == 0) {
+			t.Errorf("page %d: BOS flag %v", i, bos)
+		}
+		if eos := page.Type&FlagEOS != 0; eos != (i == len(d.pages)-1) {
+			t.Errorf("page %d: EOS flag %v", i, eos)
+		}
+	}
+
+	// The identification header is alone on the first page
+	first := d.pages[0]
+	if len(first.Segments) != 1 || first.Granule != 0 {
+		t.Errorf("first page: %d segments, granule %d", len(first.Segments), first.Granule)
+	}
+
+	// Each page is stamped with the samples decoded up to the last packet completed on it
+	samples := testSamples(d.packets[3:])
+	var (
+		splitter packetSplitter
+		packets  int
+		granule  int64
+	)
+	for i, page := range d.pages {
+		for range splitter.push(page) {
+			if packets >= 3 {
+				granule += samples[packets-3]
+			}
+			packets++
+		}
+		if i >= 2 && page.Granule != granule {
+			t.Errorf("page %d: granule %d, want %d", i, page.Granule, granule)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// CRC-32 with polynomial 0x04c11db7, no reflection, zero initial value and no final xor
+	if got := checksum([]byte("123456789")); got != 0x89a1897f {
+		t.Fatalf("checksum = %#x, want 0x89a1897f", got)
+	}
+}
+
+func TestRepairValid(t *testing.T) {
+	valid := fixture(t, "valid.ogg", buildValid)
+
+	var out bytes.Buffer
+	stats, err := Repair(&out, bytes.NewReader(valid), Params{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.RebuiltIdentification || stats.SkippedBytes != 0 {
+		t.Errorf("stats = %+v, want an untouched stream", *stats)
+	}
+	if !bytes.Equal(out.Bytes(), valid) {
+		t.Error("repairing a valid stream changed it")
+	}
+
+	d := decode(t, out.Bytes())
+	checkStructure(t, d)
+	if stats.Packets != len(d.packets)-3 || stats.Pages != len(d.pages) {
+		t.Errorf("stats = %+v for %d pages and %d packets", *stats, len(d.pages), len(d.packets))
+	}
+	if last := d.pages[len(d.pages)-1].Granule; stats.Samples != last {
+		t.Errorf("samples = %d, last granule %d", stats.Samples, last)
+	}
+}
+
+func TestRepairDamaged(t *testing.T) {
+	damaged := fixture(t, "damaged.ogg", buildDamaged)
+	valid := decode(t, fixture(t, "valid.ogg", buildValid))
+
+	var out bytes.Buffer
+	stats, err := Repair(&out, bytes.NewReader(damaged), SpotifyParams, func(c *Comments) {
+		c.Set("ARTIST", "Tester")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.RebuiltIdentification {
+		t.Error("the scrambled identification header was kept")
+	}
+	// The garbage, the overwritten page and the trailing bytes
+	if want := int64(7 + 4575 + 3); stats.SkippedBytes != want {
+		t.Errorf("skipped %d bytes, want %d", stats.SkippedBytes, want)
+	}
+
+	d := decode(t, out.Bytes())
+	checkStructure(t, d)
+
+	id, err := parseIdentification(d.packets[0])
+	if err != nil {
+		t.Fatalf("rebuilt identification header: %v", err)
+	}
+	if id != SpotifyParams || !bytes.Equal(d.packets[0], SpotifyParams.identification()) {
+		t.Errorf("rebuilt identification header = %+v", id)
+	}
+
+	comments, err := ParseComments(d.packets[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comments.Get("TITLE") != "Fixture" || comments.Get("ARTIST") != "Tester" {
+		t.Errorf("comments = %+v", comments)
+	}
+	if !bytes.Equal(d.packets[2], valid.packets[2]) {
+		t.Error("setup header changed")
+	}
+
+	// The packets of the lost page are dropped whole, every other packet comes through intact
+	original := make(map[string]bool)
+	for _, p := range valid.packets[3:] {
+		original[string(p)] = true
+	}
+	audio := d.packets[3:]
+	if len(audio) >= len(valid.packets)-3 || len(audio) != stats.Packets {
+		t.Errorf("got %d audio packets of %d, stats say %d", len(audio), len(valid.packets)-3, stats.Packets)
+	}
+	for i, p := range audio {
+		if !original[string(p)] {
+			t.Errorf("audio packet %d (%d bytes) is not one of the originals", i, len(p))
+		}
+	}
+}
+
+func TestRepairUnrecoverable(t *testing.T) {
+	valid := fixture(t, "valid.ogg", buildValid)
+	damaged := fixture(t, "damaged.ogg", buildDamaged)
+	headers := len(valid) - len(bytes.SplitAfterN(valid, capturePattern, 4)[3]) - len(capturePattern)
+
+	tests := []struct {
+		name   string
+		input  []byte
+		params Params
+	}{
+		{"empty", nil, SpotifyParams},
+		{"shorter than a page", valid[:40], SpotifyParams},
+		{"garbage", bytes.Repeat([]byte("garbage"), 1000), SpotifyParams},
+		{"setup header missing", valid[:identificationPageSize+60], SpotifyParams},
+		{"no audio", valid[:headers], SpotifyParams},
+		{"no parameters to rebuild from", damaged, Params{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Repair(io.Discard, bytes.NewReader(tt.input), tt.params, nil)
+			if !errors.Is(err, ErrUnrecoverable) {
+				t.Fatalf("err = %v, want ErrUnrecoverable", err)
+			}
+		})
+	}
+}
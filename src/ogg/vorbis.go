@@ -0,0 +1,195 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	vorbisSetupMagic = []byte("\x05vorbis")
+
+	errBadIdentification = errors.New("ogg: malformed Vorbis identification header")
+	errBadSetup          = errors.New("ogg: cannot locate the modes of the Vorbis setup header")
+)
+
+// identificationSize is the length of a Vorbis identification header packet
+const identificationSize = 30
+
+// Params are the stream parameters carried by a Vorbis identification header
+type Params struct {
+	Channels       uint8
+	SampleRate     uint32
+	BitrateMax     int32
+	BitrateNominal int32
+	BitrateMin     int32
+	Blocksize0     uint8 // log2 of the short block size
+	Blocksize1     uint8 // log2 of the long block size
+}
+
+// SpotifyParams describe the streams served by the Spotify CDN (44.1 kHz stereo, 320 kbps)
+var SpotifyParams = Params{
+	Channels:       2,
+	SampleRate:     44100,
+	BitrateNominal: 320000,
+	Blocksize0:     8,
+	Blocksize1:     11,
+}
+
+// parseIdentification decodes and validates a Vorbis identification header
+func parseIdentification(packet []byte) (Params, error) {
+	if len(packet) < identificationSize || !bytes.HasPrefix(packet, vorbisIDMagic) {
+		return Params{}, errBadIdentification
+	}
+
+	p := Params{
+		Channels:       packet[11],
+		SampleRate:     binary.LittleEndian.Uint32(packet[12:]),
+		BitrateMax:     int32(binary.LittleEndian.Uint32(packet[16:])),
+		BitrateNominal: int32(binary.LittleEndian.Uint32(packet[20:])),
+		BitrateMin:     int32(binary.LittleEndian.Uint32(packet[24:])),
+		Blocksize0:     packet[28] & 0x0F,
+		Blocksize1:     packet[28] >> 4,
+	}
+
+	version := binary.LittleEndian.Uint32(packet[7:])
+	if version != 0 || packet[29]&1 == 0 || !p.valid() {
+		return Params{}, errBadIdentification
+	}
+	return p, nil
+}
+
+// valid checks the constraints the Vorbis specification puts on the parameters
+func (p Params) valid() bool {
+	return p.Channels > 0 && p.SampleRate > 0 &&
+		p.Blocksize0 >= 6 && p.Blocksize1 <= 13 && p.Blocksize0 <= p.Blocksize1
+}
+
+// identification encodes the parameters as an identification header packet
+func (p Params) identification() []byte {
+	packet := make([]byte, identificationSize)
+	copy(packet, vorbisIDMagic)
+	packet[11] = p.Channels
+	binary.LittleEndian.PutUint32(packet[12:], p.SampleRate)
+	binary.LittleEndian.PutUint32(packet[16:], uint32(p.BitrateMax))
+	binary.LittleEndian.PutUint32(packet[20:], uint32(p.BitrateNominal))
+	binary.LittleEndian.PutUint32(packet[24:], uint32(p.BitrateMin))
+	packet[28] = p.Blocksize1<<4 | p.Blocksize0
+	packet[29] = 1 // framing bit
+	return packet
+}
+
+// reverseBits reads a byte slice backwards, so that fields packed LSB-first come out in reverse order
+type reverseBits struct {
+	buf []byte // reversed copy of the packet
+	pos int
+}
+
+func newReverseBits(packet []byte) *reverseBits {
+	buf := make([]byte, len(packet))
+	for i, b := range packet {
+		buf[len(packet)-1-i] = b
+	}
+	return &reverseBits{buf: buf}
+}
+
+func (r *reverseBits) left() int {
+	return len(r.buf)*8 - r.pos
+}
+
+func (r *reverseBits) read(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		bit := r.buf[r.pos/8] >> (7 - r.pos%8) & 1
+		v = v<<1 | uint32(bit)
+		r.pos++
+	}
+	return v
+}
+
+// packetTimer computes the number of samples each audio packet decodes to
+type packetTimer struct {
+	blocksizes [2]int64
+	modes      []bool // block flag of each mode
+	modeBits   int
+	previous   int64
+}
+
+// newPacketTimer reads the mode configuration, the last field of the setup header.
+// The setup header cannot be walked forward without decoding the codebooks, so like libogg
+// and FFmpeg we scan it backwards from the framing bit and keep the last consistent mode count.
+func newPacketTimer(p Params, setup []byte) (*packetTimer, error) {
+	if !bytes.HasPrefix(setup, vorbisSetupMagic) {
+		return nil, errBadSetup
+	}
+
+	r := newReverseBits(setup)
+	framing := -1
+	for r.left() > 97 {
+		if r.read(1) == 1 {
+			framing = r.pos
+			break
+		}
+	}
+	if framing < 0 {
+		return nil, errBadSetup
+	}
+
+	count, modeCount := 0, 0
+	for r.left() >= 97 {
+		if r.read(8) > 63 || r.read(16) != 0 || r.read(16) != 0 {
+			break
+		}
+		r.read(1)
+		if count++; count > 64 {
+			break
+		}
+		peek := *r
+		if int(peek.read(6))+1 == count {
+			modeCount = count
+		}
+	}
+	if modeCount == 0 {
+		return nil, errBadSetup
+	}
+
+	t := &packetTimer{
+		blocksizes: [2]int64{1 << p.Blocksize0, 1 << p.Blocksize1},
+		modes:      make([]bool, modeCount),
+	}
+	for v := modeCount - 1; v > 0; v >>= 1 {
+		t.modeBits++
+	}
+
+	r.pos = framing
+	for i := modeCount - 1; i >= 0; i-- {
+		r.read(40)
+		t.modes[i] = r.read(1) == 1
+	}
+	return t, nil
+}
+
+// samples returns how many samples the audio packet adds to the stream
+func (t *packetTimer) samples(packet []byte) int64 {
+	if len(packet) == 0 || packet[0]&1 != 0 {
+		return 0
+	}
+
+	mode := int(packet[0]>>1) & (1<<t.modeBits - 1)
+	if mode >= len(t.modes) {
+		return 0
+	}
+
+	current := t.blocksizes[0]
+	if t.modes[mode] {
+		current = t.blocksizes[1]
+	}
+
+	// The first packet only primes the overlap and produces no output
+	var n int64
+	if t.previous > 0 {
+		n = (t.previous + current) / 4
+	}
+	t.previous = current
+	return n
+}
@@ -0,0 +1,139 @@
+package ogg
+
+import (
+	"errors"
+	"io"
+)
+
+// DefaultPageSize is the body size after which the writer starts a new page, as libvorbis does
+const DefaultPageSize = 4096
+
+var errWriterClosed = errors.New("ogg: write to closed writer")
+
+// Writer paginates packets of a single logical stream.
+// The last page is held back until Close so that it can carry the end-of-stream flag.
+type Writer struct {
+	w        io.Writer
+	serial   uint32
+	sequence uint32
+	pageSize int
+
+	cur     *Page // page being filled
+	held    *Page // complete page not written yet
+	started bool
+	closed  bool
+}
+
+// NewWriter returns a writer emitting pages with the given serial number
+func NewWriter(w io.Writer, serial uint32) *Writer {
+	return &Writer{w: w, serial: serial, pageSize: DefaultPageSize}
+}
+
+// WritePacket appends a packet whose last sample is at granule.
+// Packets are packed into pages of about DefaultPageSize bytes; use Flush to force a page boundary.
+func (w *Writer) WritePacket(packet []byte, granule int64) error {
+	if w.closed {
+		return errWriterClosed
+	}
+
+	remaining := packet
+	split := false // part of the packet was already written to a previous page
+	for {
+		if w.cur == nil {
+			w.cur = &Page{Granule: -1}
+			if split {
+				w.cur.Type = FlagContinued
+			}
+		}
+
+		// Lacing: one 255 value per full segment and a final value below 255
+		for len(remaining) >= maxSegmentSize && len(w.cur.Segments) < maxSegments {
+			w.cur.Segments = append(w.cur.Segments, maxSegmentSize)
+			w.cur.Body = append(w.cur.Body, remaining[:maxSegmentSize]...)
+			remaining = remaining[maxSegmentSize:]
+			split = true
+		}
+
+		if len(w.cur.Segments) == maxSegments {
+			if err := w.emit(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		w.cur.Segments = append(w.cur.Segments, byte(len(remaining)))
+		w.cur.Body = append(w.cur.Body, remaining...)
+		w.cur.Granule = granule
+		break
+	}
+
+	if len(w.cur.Body) >= w.pageSize {
+		return w.emit()
+	}
+	return nil
+}
+
+// Flush ends the current page
+func (w *Writer) Flush() error {
+	if w.cur == nil || len(w.cur.Segments) == 0 {
+		return nil
+	}
+	return w.emit()
+}
+
+// WritePage copies a complete page, renumbering it into this stream. Pending packets are flushed first.
+func (w *Writer) WritePage(p *Page) error {
+	if w.closed {
+		return errWriterClosed
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	page := *p
+	w.cur = &page
+	return w.emit()
+}
+
+// Close flushes the remaining packets and writes the last page with the end-of-stream flag
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	w.closed = true
+
+	if w.held == nil {
+		return nil
+	}
+	w.held.Type |= FlagEOS
+	_, err := w.w.Write(w.held.Bytes())
+	w.held = nil
+	return err
+}
+
+// emit finalizes the current page and writes out the previously held one
+func (w *Writer) emit() error {
+	page := w.cur
+	w.cur = nil
+
+	page.Serial = w.serial
+	page.Sequence = w.sequence
+	w.sequence++
+
+	page.Type &^= FlagBOS | FlagEOS
+	if !w.started {
+		page.Type |= FlagBOS
+		w.started = true
+	}
+
+	if w.held != nil {
+		if _, err := w.w.Write(w.held.Bytes()); err != nil {
+			return err
+		}
+	}
+	w.held = page
+	return nil
+}
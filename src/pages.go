@@ -0,0 +1,118 @@
+package src
+
+import (
+	"fmt"
+	"songBot/src/utils"
+	"strconv"
+	"strings"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// tracksPerPage is how many track buttons a result page shows
+const tracksPerPage = 8
+
+// resultsPage is the text and keyboard of one page of a result set; without an ID (the set was
+// not saved) only the first page is shown, without navigation
+func resultsPage(id string, set *utils.ResultSet, page int) (string, telegram.ReplyMarkup) {
+	tracks, page, pages := set.Page(page, tracksPerPage)
+
+	formatSuffix := ""
+	if set.Format != "" {
+		formatSuffix = "_" + set.Format
+	}
+
+	kb := telegram.NewKeyboard()
+	for _, track := range tracks {
+		data := fmt.Sprintf("spot_%s_%d%s", utils.EncodeURL(track.URL), set.Owner, formatSuffix)
+		kb.AddRow(telegram.Button.Data(fmt.Sprintf("%s - %s", track.Name, track.Artist), data))
+	}
+
+	text := "<b>🎧 Select a song from below:</b>"
+	if id == "" {
+		return text, kb.Build()
+	}
+
+	if pages > 1 {
+		text += fmt.Sprintf("\n\n%d tracks, page %d of %d", len(set.Tracks), page+1, pages)
+
+		// The arrows wrap around; the counter in the middle just refreshes the page
+		prev, next := (page+pages-1)%pages, (page+1)%pages
+		kb.AddRow(
+			telegram.Button.Data("◀️", fmt.Sprintf("page_%s_%d", id, prev)),
+			telegram.Button.Data(fmt.Sprintf("%d/%d", page+1, pages), fmt.Sprintf("page_%s_%d", id, page)),
+			telegram.Button.Data("▶️", fmt.Sprintf("page_%s_%d", id, next)),
+		)
+	}
+	kb.AddRow(telegram.Button.Data(fmt.Sprintf("📦 Download all as ZIP (%d)", len(set.Tracks)), "zipall_"+id))
+	return text, kb.Build()
+}
+
+// loadResults returns the result set behind a page or zipall button, answering the callback when it can't be used
+func loadResults(cb *telegram.CallbackQuery, id string) *utils.ResultSet {
+	set, err := utils.GetResults(id)
+	if err != nil {
+		_, _ = cb.Answer("⌛ These results have expired, please search again.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	if set.Owner != 0 && set.Owner != cb.SenderID {
+		_, _ = cb.Answer("🚫 This action is not meant for you.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	return set
+}
+
+// pageCallback switches a result message to another page: page_<id>_<page>
+func pageCallback(cb *telegram.CallbackQuery) error {
+	parts := strings.Split(cb.DataString(), "_")
+	if len(parts) != 3 {
+		_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	page, err := strconv.Atoi(parts[2])
+	if err != nil {
+		_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	set := loadResults(cb, parts[1])
+	if set == nil {
+		return nil
+	}
+
+	text, markup := resultsPage(parts[1], set, page)
+	_, _ = cb.Edit(text, &telegram.SendOptions{ReplyMarkup: markup})
+	_, _ = cb.Answer("")
+	return nil
+}
+
+// zipAllCallback queues every track of a result set as a ZIP: zipall_<id>.
+// The progress goes to a new message so the results stay browsable.
+func zipAllCallback(cb *telegram.CallbackQuery) error {
+	id := strings.TrimPrefix(cb.DataString(), "zipall_")
+	set := loadResults(cb, id)
+	if set == nil {
+		return nil
+	}
+
+	var override *utils.AudioFormat
+	if set.Format != "" {
+		f, err := utils.ParseFormat(set.Format)
+		if err != nil {
+			_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
+			return nil
+		}
+		override = &f
+	}
+
+	_, _ = cb.Answer("📦 Preparing the ZIP...")
+	msg, err := cb.Respond(fmt.Sprintf("⏳ Found %d tracks. Preparing download...", len(set.Tracks)))
+	if err != nil {
+		return nil
+	}
+
+	tracks := &utils.PlatformTracks{Results: set.Tracks}
+	queuePlaylistZip(cb.SenderID, set.Query, msg, tracks, requestFormat(cb.SenderID, override))
+	return nil
+}
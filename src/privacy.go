@@ -2,6 +2,9 @@ package src
 
 import (
 	"fmt"
+	"songBot/src/config"
+	"strings"
+	"time"
 
 	"github.com/amarnathcjd/gogram/telegram"
 )
@@ -22,9 +25,7 @@ func privacyHandle(m *telegram.NewMessage) error {
 Thank you for using <b>@%s</b>. Your privacy is important to us. This policy explains how your data is handled.
 
 <b>📌 1. What We Store</b>
-- No usernames, messages, files, user id, chat id or queries are stored.
-- in another words, we do not collect any data from you.
-- We do not use any tracking or analytics services.
+%s
 
 <b>⚙️ 2. How the Bot Works</b>
 @%s helps you download songs from platforms like:
@@ -55,7 +56,7 @@ If you have any questions or concerns:
 <a href="%s">@FallenProjects</a> (Telegram)
 or open an issue on GitHub.
 `,
-		botName, botUsername, botUsername, githubURL, githubURL, contactURL,
+		botName, botUsername, storedDataText(), botUsername, githubURL, githubURL, contactURL,
 	)
 
 	keyboard := telegram.NewKeyboard().
@@ -69,3 +70,37 @@ or open an issue on GitHub.
 	})
 	return err
 }
+
+// storedDataText is the "What We Store" section, which depends on whether /history is enabled
+func storedDataText() string {
+	lines := []string{
+		"- No usernames, messages or chat ids are stored.",
+		fmt.Sprintf("- So the buttons under your results keep working, your search query or shared link, the tracks found and your user id are kept for %s, as are the track links behind the buttons. They are deleted after that.", formatRetention(config.Cfg.TokenTTL)),
+		"- If you pick an output format with /format, it is kept with your user id until you choose Original again, which deletes it.",
+		"- Tracks you tap ❤️ on are kept with your user id (track id, name, artist, album, platform) until you tap ❤️ again, so /favorites can list and export them.",
+	}
+	if config.Cfg.HistoryEnabled {
+		lines = append(lines,
+			fmt.Sprintf("- Download history is <b>off</b> unless you turn it on with /history on. Then, for your latest %d downloads, we keep your user id and the track id, name, artist, platform, download time and Telegram file id, so /history can send them again.", config.Cfg.HistoryLimit),
+			"- /history clear deletes the recorded tracks, /history off deletes everything and stops recording.",
+		)
+	} else {
+		lines = append(lines, "- No download history is kept.")
+	}
+	lines = append(lines,
+		"- The bot counts requests, errors and timings without saying who made them. To count daily active users, a hash of your user id is kept until the day ends. It is keyed with a random secret that changes every day and is never saved, so it cannot be traced back to you.",
+		"- We do not use any third-party tracking or analytics services.",
+	)
+	return strings.Join(lines, "\n")
+}
+
+// formatRetention writes how long data is kept in days or hours, e.g. "7 days"
+func formatRetention(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return d.String()
+}
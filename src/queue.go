@@ -0,0 +1,133 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"songBot/src/config"
+	"songBot/src/metrics"
+	"songBot/src/utils"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// noticeTimeout bounds how long Shutdown waits for the restart notices to be sent
+const noticeTimeout = 10 * time.Second
+
+// restartingText replaces the status message of a download stopped by a shutdown
+const restartingText = "♻️ The bot is restarting, please try again in a minute."
+
+var (
+	// downloadQueue runs every download, single tracks ahead of playlists
+	downloadQueue = utils.NewScheduler(config.Cfg.QueueWorkers)
+	// waiting counts the runQueued calls that have not reported their outcome yet
+	waiting atomic.Int64
+)
+
+func init() {
+	metrics.NewGaugeFunc("songbot_queue_queued_jobs", "Jobs waiting in the download queue.", func() float64 {
+		queued, _ := downloadQueue.Depth()
+		return float64(queued)
+	})
+	metrics.NewGaugeFunc("songbot_queue_running_jobs", "Jobs running in the download queue.", func() float64 {
+		_, running := downloadQueue.Depth()
+		return float64(running)
+	})
+}
+
+// cancelMarkup returns the keyboard with the ❌ Cancel button of a job
+func cancelMarkup(jobID string) telegram.ReplyMarkup {
+	return telegram.NewKeyboard().AddRow(
+		telegram.Button.Data("❌ Cancel", "cancel_"+jobID),
+	).Build()
+}
+
+// queuedText tells the user where their job waits in the queue
+func queuedText(position int) string {
+	return fmt.Sprintf("⏳ You are #%d in queue, the download starts soon...", position)
+}
+
+// runQueued submits a job and waits for it, reporting a cancellation or a shutdown through edit;
+// how it ended is counted in the stats of kind
+func runQueued(kind string, job *utils.Job, edit func(text string)) {
+	waiting.Add(1)
+	defer waiting.Add(-1)
+
+	downloadQueue.Submit(job)
+	err := job.Wait()
+	utils.RecordOutcome(kind, utils.ErrorClass(err))
+	switch {
+	case errors.Is(err, utils.ErrShuttingDown):
+		edit(restartingText)
+	case errors.Is(err, utils.ErrJobCancelled):
+		edit("❌ Download cancelled.")
+	}
+}
+
+// Shutdown stops taking downloads and lets the running ones finish until ctx is done; the users of
+// the queued and cancelled ones are told to retry. It returns an error when downloads were still running.
+func Shutdown(ctx context.Context) error {
+	err := downloadQueue.Shutdown(ctx)
+
+	deadline := time.Now().Add(noticeTimeout)
+	for waiting.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// cancelJobCallback cancels a queued or running download from its ❌ Cancel button
+func cancelJobCallback(cb *telegram.CallbackQuery) error {
+	jobID := strings.TrimPrefix(cb.DataString(), "cancel_")
+	job, ok := downloadQueue.Get(jobID)
+	if !ok {
+		_, _ = cb.Answer("✅ This download has already finished.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	if job.UserID != cb.SenderID && !config.Cfg.IsOwner(cb.SenderID) {
+		_, _ = cb.Answer("🚫 This action is not meant for you.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	downloadQueue.Cancel(jobID)
+	_, _ = cb.Answer("❌ Cancelling the download...")
+	return nil
+}
+
+// queueHandle lists the queued and running downloads; "/queue kill <id>" cancels one
+func queueHandle(m *telegram.NewMessage) error {
+	args := strings.Fields(m.Args())
+	if len(args) == 2 && args[0] == "kill" {
+		if !downloadQueue.Cancel(args[1]) {
+			_, _ = m.Reply("❌ No job with ID <code>" + args[1] + "</code>.")
+			return nil
+		}
+		_, _ = m.Reply("✅ Cancelled job <code>" + args[1] + "</code>.")
+		return nil
+	}
+
+	jobs := downloadQueue.Jobs()
+	if len(jobs) == 0 {
+		_, _ = m.Reply("📭 The download queue is empty.")
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>📋 Download queue</b> (%d jobs)\n\n", len(jobs)))
+	for _, job := range jobs {
+		state := fmt.Sprintf("#%d", job.Position)
+		if job.Running {
+			state = "▶️"
+		}
+		sb.WriteString(fmt.Sprintf("%s <code>%s</code> %s\n└ user <code>%d</code>, %s\n",
+			state, job.ID, job.Title, job.UserID, time.Since(job.Since).Round(time.Second)))
+	}
+	sb.WriteString("\nUse <code>/queue kill &lt;id&gt;</code> to cancel a job.")
+
+	_, err := m.Reply(sb.String())
+	return err
+}
@@ -0,0 +1,112 @@
+package src
+
+import (
+	"fmt"
+	"math"
+	"songBot/src/config"
+	"songBot/src/utils"
+	"sync"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// rateBudget limits an action per user and, with a larger budget, per chat
+type rateBudget struct {
+	mu   sync.Mutex // checks and spends both budgets as one step
+	user *utils.RateLimiter
+	chat *utils.RateLimiter
+}
+
+var (
+	searchBudget   = newRateBudget(config.Cfg.SearchRate)
+	inlineBudget   = newRateBudget(config.Cfg.InlineRate)
+	trackBudget    = newRateBudget(config.Cfg.TrackRate)
+	playlistBudget = newRateBudget(config.Cfg.PlaylistRate)
+)
+
+func newRateBudget(rate config.Rate) *rateBudget {
+	return &rateBudget{
+		user: utils.NewRateLimiter(rate.Count, rate.Per),
+		chat: utils.NewRateLimiter(rate.Count*config.Cfg.ChatRateFactor, rate.Per),
+	}
+}
+
+// allow spends one event of the user and chat budgets; a zero chatID only checks the user.
+// Owners and sudo users are never limited.
+func (b *rateBudget) allow(userID, chatID int64) (bool, time.Duration) {
+	if config.Cfg.IsSudo(userID) {
+		return true, 0
+	}
+
+	userKey := fmt.Sprintf("u:%d", userID)
+	if chatID == 0 || chatID == userID {
+		return b.user.Allow(userKey)
+	}
+
+	chatKey := fmt.Sprintf("c:%d", chatID)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if wait := max(b.user.Wait(userKey), b.chat.Wait(chatKey)); wait > 0 {
+		return false, wait
+	}
+	if ok, wait := b.user.Allow(userKey); !ok {
+		return false, wait
+	}
+	return b.chat.Allow(chatKey)
+}
+
+// rateLimitText tells the user how long to wait before retrying
+func rateLimitText(wait time.Duration) string {
+	return fmt.Sprintf("⏳ Please wait %ds before trying again.", int(math.Ceil(wait.Seconds())))
+}
+
+// limitMessage wraps a message handler with a rate budget
+func limitMessage(b *rateBudget, handler func(m *telegram.NewMessage) error) func(m *telegram.NewMessage) error {
+	return func(m *telegram.NewMessage) error {
+		if ok, wait := b.allow(m.SenderID(), m.ChatID()); !ok {
+			_, _ = m.Reply(rateLimitText(wait))
+			return nil
+		}
+		return handler(m)
+	}
+}
+
+// limitCallback wraps a callback handler with a rate budget
+func limitCallback(b *rateBudget, handler func(cb *telegram.CallbackQuery) error) func(cb *telegram.CallbackQuery) error {
+	return func(cb *telegram.CallbackQuery) error {
+		if ok, wait := b.allow(cb.SenderID, cb.ChatID); !ok {
+			_, _ = cb.Answer(rateLimitText(wait), &telegram.CallbackOptions{Alert: true})
+			return nil
+		}
+		return handler(cb)
+	}
+}
+
+// limitInline wraps an inline query handler with a rate budget
+func limitInline(b *rateBudget, handler func(query *telegram.InlineQuery) error) func(query *telegram.InlineQuery) error {
+	return func(query *telegram.InlineQuery) error {
+		if ok, wait := b.allow(query.SenderID, 0); !ok {
+			builder := query.Builder()
+			builder.Article("⏳ Slow down", rateLimitText(wait), rateLimitText(wait))
+			_, _ = query.Answer(builder.Results())
+			return nil
+		}
+		return handler(query)
+	}
+}
+
+// limitInlineSend wraps the chosen inline result handler with a rate budget
+func limitInlineSend(b *rateBudget, handler func(update telegram.Update, client *telegram.Client) error) func(update telegram.Update, client *telegram.Client) error {
+	return func(update telegram.Update, client *telegram.Client) error {
+		send, ok := update.(*telegram.UpdateBotInlineSend)
+		if !ok {
+			return handler(update, client)
+		}
+		if ok, wait := b.allow(send.UserID, 0); !ok {
+			_, _ = client.EditMessage(&send.MsgID, 0, rateLimitText(wait))
+			return nil
+		}
+		return handler(update, client)
+	}
+}
@@ -0,0 +1,71 @@
+package src
+
+import (
+	"songBot/src/config"
+	"testing"
+	"time"
+)
+
+func TestRateBudgetChat(t *testing.T) {
+	b := newRateBudget(config.Rate{Count: 2, Per: time.Minute})
+	chatLimit := 2 * config.Cfg.ChatRateFactor
+	const chat = -100
+
+	// Users spend their own budget and the chat's, until the chat's runs out
+	spent := 0
+	for user := int64(1); spent < chatLimit; user++ {
+		for i := 0; i < 2; i++ {
+			if ok, wait := b.allow(user, chat); !ok {
+				t.Fatalf("user %d, event %d: denied, wait %s", user, i+1, wait)
+			}
+			spent++
+		}
+		if ok, _ := b.allow(user, chat); ok {
+			t.Fatalf("user %d: allowed beyond the user budget", user)
+		}
+	}
+
+	const late = 1000
+	if ok, wait := b.allow(late, chat); ok || wait <= 0 {
+		t.Fatalf("allowed beyond the chat budget (wait %s)", wait)
+	}
+	// The chat denial took nothing from the user budget, which still works in private
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.allow(late, late); !ok {
+			t.Fatalf("private event %d denied", i+1)
+		}
+	}
+}
+
+func TestRateBudgetSudo(t *testing.T) {
+	saved := config.Cfg.SudoIDs
+	config.Cfg.SudoIDs = []int64{42}
+	t.Cleanup(func() { config.Cfg.SudoIDs = saved })
+
+	b := newRateBudget(config.Rate{Count: 1, Per: time.Hour})
+	for i := 0; i < 10; i++ {
+		if ok, _ := b.allow(42, -100); !ok {
+			t.Fatal("a sudo user was limited")
+		}
+	}
+}
+
+func TestInlineBudget(t *testing.T) {
+	if config.Cfg.InlineRate.Count == 0 || config.Cfg.SearchRate.Count == 0 {
+		t.Skip("inline or search limits are off")
+	}
+
+	// Typing an inline query spends only the inline budget
+	const user = 7001
+	for i := 0; i < config.Cfg.InlineRate.Count; i++ {
+		if ok, _ := inlineBudget.allow(user, 0); !ok {
+			t.Fatalf("inline query %d denied", i+1)
+		}
+	}
+	if ok, _ := inlineBudget.allow(user, 0); ok {
+		t.Error("allowed beyond the inline budget")
+	}
+	if ok, _ := searchBudget.allow(user, 0); !ok {
+		t.Error("inline queries spent the search budget")
+	}
+}
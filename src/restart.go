@@ -0,0 +1,35 @@
+package src
+
+import (
+	"fmt"
+	"html"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// restartFunc restarts the bot for a reason; main sets it before the clients start
+var restartFunc func(reason string) error
+
+// SetRestartFunc sets what /restart calls
+func SetRestartFunc(fn func(reason string) error) {
+	restartFunc = fn
+}
+
+// restartHandle restarts the bot with the configured restart mode
+func restartHandle(m *telegram.NewMessage) error {
+	if restartFunc == nil {
+		_, _ = m.Reply("❌ Restarts are not available.")
+		return nil
+	}
+
+	msg, _ := m.Reply("♻️ Restarting the bot, running downloads finish first...")
+	if err := restartFunc(fmt.Sprintf("/restart by %d", m.SenderID())); err != nil {
+		text := "❌ Restart failed: " + html.EscapeString(err.Error())
+		if msg != nil {
+			_, _ = msg.Edit(text)
+		} else {
+			_, _ = m.Reply(text)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package src
+
+import (
+	"fmt"
+	"html"
+	"songBot/src/config"
+	"strings"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// configHandle shows the effective configuration with secrets redacted
+func configHandle(m *telegram.NewMessage) error {
+	var sb strings.Builder
+	sb.WriteString("<b>⚙️ Configuration</b>\n\n")
+	for _, setting := range config.Cfg.Describe() {
+		sb.WriteString(fmt.Sprintf("<b>%s:</b> <code>%s</code>\n", setting[0], html.EscapeString(setting[1])))
+	}
+
+	_, err := m.Reply(sb.String())
+	return err
+}
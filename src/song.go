@@ -1,13 +1,15 @@
 package src
 
 import (
+	"context"
 	"fmt"
 	"github.com/amarnathcjd/gogram/telegram"
 	"os"
-	"regexp"
+	"songBot/src/config"
 	"songBot/src/utils"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // spotifySearchSong handles user input for searching Spotify tracks.
@@ -17,201 +19,377 @@ func spotifySearchSong(m *telegram.NewMessage) error {
 		query = m.Args()
 	}
 
+	query, override, err := parseFormatFlag(query)
+	if err != nil {
+		_, _ = m.Reply("❌ " + err.Error() + "\n\n" + formatUsage())
+		return nil
+	}
+
 	if query == "" {
 		_, err := m.Reply("❗ Please provide a song name or Spotify URL.")
 		return err
 	}
 
-	api := utils.NewApiData(query)
-	kb := telegram.NewKeyboard()
-
-	if api.IsValid(query) {
-		song, err := api.GetInfo()
-		if err != nil || song == nil || len(song.Results) == 0 {
+	utils.RecordRequest(utils.StatSearch, m.SenderID())
+	tracks, isURL, err := utils.Provider().Resolve(query, config.Cfg.SearchLimit)
+	if isURL {
+		utils.RecordPlatform(utils.URLPlatform(query))
+	}
+	if err != nil || tracks == nil || len(tracks.Results) == 0 {
+		utils.RecordOutcome(utils.StatSearch, noResultsClass(err))
+		if isURL {
 			_, _ = m.Reply("😢 Song not found.")
-			return nil
-		}
-
-		for _, track := range song.Results {
-			data := fmt.Sprintf("spot_%s_0", utils.EncodeURL(track.URL))
-			kb.AddRow(telegram.Button.Data(fmt.Sprintf("%s - %s", track.Name, track.Artist), data))
-		}
-	} else {
-		search, err := api.Search("5")
-		if err != nil || len(search.Results) == 0 {
+		} else {
 			_, _ = m.Reply("😔 No results found.")
-			return nil
 		}
+		return nil
+	}
 
-		for _, track := range search.Results {
-			data := fmt.Sprintf("spot_%s_%d", utils.EncodeURL(track.URL), m.SenderID())
-			kb.AddRow(telegram.Button.Data(fmt.Sprintf("%s - %s", track.Name, track.Artist), data))
-		}
+	// Results of a shared URL may be picked by anyone in the chat, search results only by the searcher.
+	// A one-off format travels with the buttons, otherwise the preference of whoever picks a track applies.
+	set := utils.ResultSet{Query: query, Tracks: tracks.Results, Owner: m.SenderID()}
+	if isURL {
+		set.Owner = 0
+	}
+	if override != nil {
+		set.Format = override.String()
 	}
 
-	_, err := m.Reply("<b>🎧 Select a song from below:</b>", telegram.SendOptions{
-		ReplyMarkup: kb.Build(),
-	})
+	// Long playlists and albums are browsed page by page, the pages are kept in the database
+	id := ""
+	if len(set.Tracks) > 1 {
+		if id, err = utils.SaveResults(set); err != nil {
+			m.Client.Log.Error(err.Error())
+		}
+	}
 
-	if err != nil {
+	text, markup := resultsPage(id, &set, 0)
+	if _, err = m.Reply(text, telegram.SendOptions{ReplyMarkup: markup}); err != nil {
 		m.Client.Log.Error(err.Error())
-		_, _ = m.Reply("⚠️ Too many results. Please use a direct track URL or reduce playlist size.")
+		_, _ = m.Reply("⚠️ Failed to show the results. Please try again.")
 	}
+	utils.RecordOutcome(utils.StatSearch, utils.ErrorClass(err))
 
 	return nil
 }
 
 // spotifyHandlerCallback handles callback queries from inline buttons.
 func spotifyHandlerCallback(cb *telegram.CallbackQuery) error {
-	data := cb.DataString()
-	split1, split2 := strings.Index(data, "_"), strings.LastIndex(data, "_")
-	if split1 == -1 || split2 == -1 || split1 == split2 {
+	// spot_<token>_<uid>[_<format>]
+	parts := strings.Split(cb.DataString(), "_")
+	if len(parts) < 3 || len(parts) > 4 {
 		_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
 		_, _ = cb.Delete()
 		return nil
 	}
 
-	idEnc := data[split1+1 : split2]
-	uid := data[split2+1:]
+	var override *utils.AudioFormat
+	if len(parts) == 4 {
+		f, err := utils.ParseFormat(parts[3])
+		if err != nil {
+			_, _ = cb.Answer("❌ Invalid selection.", &telegram.CallbackOptions{Alert: true})
+			return nil
+		}
+		override = &f
+	}
+
+	idEnc, uid := parts[1], parts[2]
 	if uid != "0" && uid != fmt.Sprint(cb.SenderID) {
 		_, _ = cb.Answer("🚫 This action is not meant for you.", &telegram.CallbackOptions{Alert: true})
 		return nil
 	}
 
 	_, _ = cb.Answer("🔄 Processing your request...", &telegram.CallbackOptions{Alert: true})
+	utils.RecordRequest(utils.StatTrack, cb.SenderID)
 	url, err := utils.DecodeURL(idEnc)
 	if err != nil {
 		cb.Client.Logger.Warn("Failed to decode URL:", err.Error())
 		_, _ = cb.Edit("❌ Failed to decode the URL.")
+		utils.RecordOutcome(utils.StatTrack, utils.ErrorClass(err))
+		return nil
+	}
+
+	sendCached := func(caption string, opts *telegram.SendOptions) error {
+		_, err := cb.Edit(caption, opts)
+		return err
+	}
+
+	format := requestFormat(cb.SenderID, override)
+	urlKey := utils.FormatCacheKey(utils.URLCacheKey(botID(cb.Client), url), format)
+	if entry, ok := sendCachedTrack(cb.Client, sendCached, urlKey); ok {
+		recordCacheHit(utils.StatTrack, entry.Platform)
+		utils.RecordDelivery(cb.SenderID, botID(cb.Client), entry.Track(), url, entry.FileID)
 		return nil
 	}
 
-	track, err := utils.NewApiData(url).GetTrack()
+	track, err := utils.Provider().GetTrack(url)
 	if err != nil {
 		cb.Client.Logger.Warn("Failed to fetch track:", err.Error())
 		_, _ = cb.Edit("❌ Could not fetch track details.")
+		utils.RecordOutcome(utils.StatTrack, utils.ErrorClass(err))
 		return nil
 	}
 
-	msg, _ := cb.Edit("⏬ Downloading the song...")
+	trackKey := utils.FormatCacheKey(utils.TrackCacheKey(botID(cb.Client), track.TC), format)
+	if entry, ok := sendCachedTrack(cb.Client, sendCached, trackKey); ok {
+		recordCacheHit(utils.StatTrack, track.Platform)
+		utils.StoreCachedFile(*entry, urlKey)
+		utils.RecordDelivery(cb.SenderID, botID(cb.Client), track, url, entry.FileID)
+		return nil
+	}
+	utils.RecordCache(false)
+	utils.RecordPlatform(track.Platform)
+
+	job := &utils.Job{
+		UserID:   cb.SenderID,
+		Title:    fmt.Sprintf("%s - %s", track.Name, track.Artist),
+		Priority: utils.PriorityHigh,
+	}
+	job.OnQueued = func(position int) {
+		_, _ = cb.Edit(queuedText(position), &telegram.SendOptions{ReplyMarkup: cancelMarkup(job.ID())})
+	}
+	job.Run = func(ctx context.Context) error {
+		return downloadTrackCallback(ctx, cb, track, url, format, cancelMarkup(job.ID()), urlKey, trackKey)
+	}
+
+	runQueued(utils.StatTrack, job, func(text string) { _, _ = cb.Edit(text) })
+	return nil
+}
+
+// downloadTrackCallback downloads a track for a callback query and replaces the message with it;
+// source is the URL the track was fetched with. A failure is reported in the message and returned.
+func downloadTrackCallback(ctx context.Context, cb *telegram.CallbackQuery, track *utils.TrackInfo, source string, format utils.AudioFormat, cancel telegram.ReplyMarkup, keys ...string) error {
+	msg, err := cb.Edit("⏬ Downloading the song...", &telegram.SendOptions{ReplyMarkup: cancel})
+	if err != nil {
+		return err
+	}
+
 	dl, err := utils.NewDownload(*track)
 	if err != nil {
 		cb.Client.Logger.Warn("Invalid download:", err)
 		_, _ = msg.Edit("⚠️ Failed to download the song." + err.Error())
-		return nil
+		return err
 	}
 
-	audioFile, thumb, err := dl.Process()
-	if err != nil || audioFile == "" {
+	dl.Format = format
+	dl.OnProgress = downloadProgress(func(text string) {
+		_, _ = msg.Edit(text, telegram.SendOptions{ReplyMarkup: cancel})
+	})
+
+	audioFile, thumb, err := dl.Process(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil && audioFile == "" {
+		err = errMissingAudio
+	}
+	if err != nil {
 		cb.Client.Logger.Warn("Download/process failed:", err)
 		_, _ = msg.Edit("⚠️ Failed to download the song.")
-		return nil
+		return err
 	}
 
-	// Check if file is a Telegram link (e.g., https://t.me/channel/1234)
-	if matches := regexp.MustCompile(`https?://t\.me/([^/]+)/(\d+)`).FindStringSubmatch(audioFile); len(matches) == 3 {
-		if id, err := strconv.Atoi(matches[2]); err == nil {
-			if ref, err := msg.Client.GetMessageByID(matches[1], int32(id)); err == nil {
-				audioFile, err = ref.Download(&telegram.DownloadOptions{FileName: ref.File.Name})
-				if err != nil {
-					_, _ = msg.Edit("⚠️ Failed to download file. " + err.Error())
-					return nil
-				}
-			}
-		}
+	if audioFile, err = downloadTelegramLink(msg.Client, audioFile); err != nil {
+		_, _ = msg.Edit("⚠️ Failed to download file. " + err.Error())
+		return err
 	}
 
 	if !fileExists(audioFile) {
 		cb.Client.Logger.Warn("Audio file does not exist:", audioFile)
 		_, _ = msg.Edit("❌ Audio file missing.")
-		return nil
+		return errMissingAudio
 	}
 
 	progress := telegram.NewProgressManager(4)
 	progress.Edit(telegram.MediaDownloadProgress(msg, progress))
 	opts := prepareTrackMessageOptions(audioFile, thumb, track, progress)
-	_, err = msg.Edit(buildTrackCaption(track), opts)
+	started := time.Now()
+	sent, err := msg.Edit(buildTrackCaption(track), opts)
 
 	if err != nil {
 		_, _ = msg.Edit("❌ Failed to send the track. " + err.Error())
-		return nil
+		return err
+	}
+	utils.ObserveDuration(utils.PhaseUpload, time.Since(started))
+
+	fileID := ""
+	if sent != nil && sent.File != nil {
+		fileID = sent.File.FileID
+		utils.StoreCachedFile(utils.NewCachedFile(track, fileID, thumb), keys...)
 	}
+	utils.RecordDelivery(cb.SenderID, botID(cb.Client), track, source, fileID)
 
 	cb.Client.Logger.Debug("Successfully sent track.")
 	return nil
 }
 
+// zipHandle sends a playlist as a ZIP, or as audio albums with -a
 func zipHandle(m *telegram.NewMessage) error {
-	query := strings.TrimSpace(m.Args())
+	query, asAlbum := parseAlbumFlag(strings.TrimSpace(m.Args()))
+	query, override, err := parseFormatFlag(query)
+	if err != nil {
+		_, _ = m.Reply("❌ " + err.Error() + "\n\n" + formatUsage())
+		return nil
+	}
+
 	if query == "" {
-		_, err := m.Reply("🎵 Please send me a song name, artist, or Spotify URL.\nExample: /playlist Daft Punk Get Lucky")
+		_, err := m.Reply("🎵 Please send me a song name, artist, or Spotify URL.\nExample: /playlist Daft Punk Get Lucky\nAdd <code>-a</code> to get the tracks as audio messages instead of a ZIP.")
 		return err
 	}
 
-	api := utils.NewApiData(query)
-	var tracks *utils.PlatformTracks
-	var err error
 	msg, err := m.Reply("🔍 Searching for tracks...")
 	if err != nil {
 		return nil
 	}
 
-	if !api.IsValid(query) {
-		tracks, err = api.Search("5")
-	} else {
-		tracks, err = api.GetInfo()
+	tracks, isURL, err := utils.Provider().Resolve(query, config.Cfg.SearchLimit)
+	if isURL {
+		utils.RecordPlatform(utils.URLPlatform(query))
 	}
-
-	if err != nil || len(tracks.Results) == 0 {
+	if err != nil || tracks == nil || len(tracks.Results) == 0 {
+		utils.RecordRequest(utils.StatPlaylist, m.SenderID())
+		utils.RecordOutcome(utils.StatPlaylist, noResultsClass(err))
 		_, _ = msg.Edit("⚠️ Couldn't find any tracks. Please try a different search.")
 		return nil
 	}
 
 	if tracks.Results[0].Platform == "youtube" {
+		utils.RecordRequest(utils.StatPlaylist, m.SenderID())
+		utils.RecordOutcome(utils.StatPlaylist, "unsupported")
 		_, _ = msg.Edit("⚠️ YouTube is not supported. Please try a different search.")
 		return nil
 	}
 
-	msg, _ = msg.Edit(fmt.Sprintf("⏳ Found %d tracks. Preparing download...", len(tracks.Results)))
-
-	// Create ZIP file
-	zipResult, err := utils.ZipTracks(tracks)
-	if err != nil {
-		_, _ = msg.Edit("❌ Failed to create zip file. Please try again later." + err.Error())
+	format := requestFormat(m.SenderID(), override)
+	if !asAlbum {
+		queuePlaylistZip(m.SenderID(), query, msg, tracks, format)
 		return nil
 	}
 
-	if !fileExists(zipResult.ZipPath) {
-		_, _ = msg.Edit("⚠️ Download completed but zip file is missing. Please report this issue.")
+	if len(tracks.Results) > config.Cfg.AlbumMaxTracks {
+		utils.RecordRequest(utils.StatPlaylist, m.SenderID())
+		utils.RecordOutcome(utils.StatPlaylist, "too_large")
+		_, _ = msg.Edit(fmt.Sprintf("⚠️ Only playlists of up to %d tracks can be sent as audio, this one has %d. Send it without <code>-a</code> to get a ZIP.", config.Cfg.AlbumMaxTracks, len(tracks.Results)))
 		return nil
 	}
+	queuePlaylistAlbum(m.SenderID(), query, msg, tracks, format)
+	return nil
+}
 
-	// Prepare final message
-	successMsg := fmt.Sprintf("✅ Success! Downloaded %d/%d tracks.\n📦 Zip file ready:",
-		zipResult.SuccessCount,
-		len(tracks.Results))
-
-	if len(zipResult.Errors) > 0 {
-		successMsg += fmt.Sprintf("\n\n⚠️ %d tracks failed to download.", len(zipResult.Errors))
+// queuePlaylistZip queues the ZIP download of tracks for a user, reporting its progress in msg
+func queuePlaylistZip(userID int64, query string, msg *telegram.NewMessage, tracks *utils.PlatformTracks, format utils.AudioFormat) {
+	utils.RecordRequest(utils.StatPlaylist, userID)
+	job := &utils.Job{
+		UserID:   userID,
+		Title:    fmt.Sprintf("Playlist: %s (%d tracks)", query, len(tracks.Results)),
+		Priority: utils.PriorityNormal,
+	}
+	job.OnQueued = func(position int) {
+		_, _ = msg.Edit(queuedText(position), telegram.SendOptions{ReplyMarkup: cancelMarkup(job.ID())})
+	}
+	job.Run = func(ctx context.Context) error {
+		return sendPlaylistZip(ctx, msg, tracks, format, cancelMarkup(job.ID()))
 	}
 
-	_, err = msg.Edit(
-		successMsg,
-		telegram.SendOptions{
-			Media:    zipResult.ZipPath,
-			MimeType: "application/zip",
-			Caption:  fmt.Sprintf("🎵 %d tracks", zipResult.SuccessCount),
-		},
-	)
+	runQueued(utils.StatPlaylist, job, func(text string) { _, _ = msg.Edit(text) })
+}
+
+// sendPlaylistZip downloads the tracks into zip files and sends them: a single archive replaces msg,
+// several parts are sent in order as replies and msg becomes the summary of what each part holds.
+// A failure is reported in msg and returned.
+func sendPlaylistZip(ctx context.Context, msg *telegram.NewMessage, tracks *utils.PlatformTracks, format utils.AudioFormat, cancel telegram.ReplyMarkup) error {
+	_, _ = msg.Edit(fmt.Sprintf("⏳ Found %d tracks. Preparing download...", len(tracks.Results)), telegram.SendOptions{ReplyMarkup: cancel})
+
+	// Create ZIP files
+	zipResult, err := utils.ZipTracks(ctx, tracks, format)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		_, _ = msg.Edit("❌ Failed to create zip file. Please try again later." + err.Error())
+		return err
+	}
 
 	defer func() {
-		_ = os.Remove(zipResult.ZipPath)
+		for _, part := range zipResult.Parts {
+			_ = os.Remove(part.Path)
+		}
 	}()
 
-	if err != nil {
-		_, _ = msg.Edit("❌ Failed to send zip file. Please try again later." + err.Error())
+	for _, part := range zipResult.Parts {
+		if !fileExists(part.Path) {
+			_, _ = msg.Edit("⚠️ Download completed but zip file is missing. Please report this issue.")
+			return errMissingZip
+		}
+	}
+
+	// Prepare final message
+	successMsg := fmt.Sprintf("✅ Success! Downloaded %d/%d tracks.", zipResult.SuccessCount, len(tracks.Results))
+	failedMsg := ""
+	if len(zipResult.Errors) > 0 {
+		failedMsg = fmt.Sprintf("\n\n⚠️ %d tracks failed to download.", len(zipResult.Errors))
+	}
+
+	if len(zipResult.Parts) == 1 {
+		part := zipResult.Parts[0]
+		started := time.Now()
+		_, err = msg.Edit(
+			successMsg+"\n📦 Zip file ready:"+failedMsg,
+			telegram.SendOptions{
+				Media:    part.Path,
+				FileName: part.Name,
+				MimeType: "application/zip",
+				Caption:  fmt.Sprintf("🎵 %d tracks", len(part.Tracks)),
+			},
+		)
+		if err != nil {
+			_, _ = msg.Edit("❌ Failed to send zip file. Please try again later." + err.Error())
+			return err
+		}
+		utils.ObserveDuration(utils.PhaseUpload, time.Since(started))
 		return nil
 	}
 
+	var summary strings.Builder
+	summary.WriteString(fmt.Sprintf("%s\n📦 Split into %d parts:\n", successMsg, len(zipResult.Parts)))
+	for i, part := range zipResult.Parts {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		_, _ = msg.Edit(fmt.Sprintf("📤 Uploading part %d of %d...", i+1, len(zipResult.Parts)), telegram.SendOptions{ReplyMarkup: cancel})
+		started := time.Now()
+		_, err = msg.ReplyMedia(part.Path, telegram.MediaOptions{
+			FileName: part.Name,
+			MimeType: "application/zip",
+			Caption:  fmt.Sprintf("🎵 Part %d of %d: %d tracks", i+1, len(zipResult.Parts), len(part.Tracks)),
+		})
+		if err != nil {
+			_, _ = msg.Edit(fmt.Sprintf("❌ Failed to send part %d of %d. Please try again later.%s", i+1, len(zipResult.Parts), err.Error()))
+			return err
+		}
+		utils.ObserveDuration(utils.PhaseUpload, time.Since(started))
+		summary.WriteString(fmt.Sprintf("\n• Part %d: tracks %s", i+1, trackRanges(part.Tracks)))
+	}
+
+	_, _ = msg.Edit(summary.String() + failedMsg)
 	return nil
 }
+
+// trackRanges writes ascending track numbers as ranges, e.g. "1-12, 14-20"
+func trackRanges(numbers []int) string {
+	var ranges []string
+	for i := 0; i < len(numbers); {
+		j := i
+		for j+1 < len(numbers) && numbers[j+1] == numbers[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(numbers[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", numbers[i], numbers[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
@@ -22,7 +22,11 @@ Supports: <b>Spotify</b>, <b>YouTube</b>, <b>Apple Music</b>, <b>SoundCloud</b>
 • Send a song name or link directly  
 • Inline: <code>@%s lofi mood</code>  
 • Group: <code>/spotify &lt;url&gt;</code>
-• Playlist: <code>/playlist &lt;url&gt;</code>
+• Playlist: <code>/playlist &lt;url&gt;</code> (add <code>-a</code> for audio messages)
+• Format: <code>/format</code> (MP3, Opus, FLAC, M4A)
+• Lyrics: <code>/lyrics &lt;song&gt;</code>
+• History: <code>/history</code> (opt-in)
+• Favorites: tap ❤️ under a track, then <code>/favorites</code>
 
 <b>⚙️ Features:</b>
 • Download songs from YouTube, Spotify, Apple Music, and SoundCloud  
@@ -0,0 +1,123 @@
+package src
+
+import (
+	"fmt"
+	"html"
+	"songBot/src/utils"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+)
+
+// statKinds are the request kinds in the order /stats shows them
+var statKinds = []struct{ kind, label string }{
+	{utils.StatSearch, "Searches"},
+	{utils.StatTrack, "Tracks"},
+	{utils.StatInline, "Inline"},
+	{utils.StatPlaylist, "Playlists"},
+}
+
+// statPhases are the timed phases in the order /stats shows them
+var statPhases = []struct{ phase, label string }{
+	{utils.PhaseDownload, "Download"},
+	{utils.PhaseDecrypt, "Download + decrypt"},
+	{utils.PhaseTranscode, "Transcode"},
+	{utils.PhaseUpload, "Upload"},
+}
+
+// recordCacheHit counts a track of the given kind re-sent from the file cache
+func recordCacheHit(kind, platform string) {
+	utils.RecordCache(true)
+	utils.RecordPlatform(platform)
+	utils.RecordOutcome(kind, "ok")
+}
+
+// noResultsClass is the outcome of a search that failed with err or found nothing
+func noResultsClass(err error) string {
+	if err == nil {
+		return "no_results"
+	}
+	return utils.ErrorClass(err)
+}
+
+// statsHandle shows what the bot did since the stats were started
+func statsHandle(m *telegram.NewMessage) error {
+	_, err := m.Reply(renderStats(utils.GetStats(), time.Now()))
+	return err
+}
+
+// renderStats writes the stats as the /stats message
+func renderStats(s utils.Stats, now time.Time) string {
+	var sb strings.Builder
+	sb.WriteString("<b>📊 Bot statistics</b>\n")
+	sb.WriteString(fmt.Sprintf("<i>Since %s</i>\n", time.Unix(s.Since, 0).UTC().Format("2 Jan 2006 15:04 MST")))
+
+	sb.WriteString("\n<b>👥 Active users</b>\n")
+	week := 0
+	for i := 0; i < 7; i++ {
+		week += s.ActiveUsers(now.AddDate(0, 0, -i))
+	}
+	sb.WriteString(fmt.Sprintf("Today: <b>%d</b> · Yesterday: <b>%d</b> · 7-day average: <b>%.1f</b>\n",
+		s.ActiveUsers(now), s.ActiveUsers(now.AddDate(0, 0, -1)), float64(week)/7))
+
+	sb.WriteString("\n<b>📥 Requests</b>\n")
+	for _, k := range statKinds {
+		outcomes := s.Outcomes[k.kind]
+		failed := int64(0)
+		for class, n := range outcomes {
+			if class != "ok" {
+				failed += n
+			}
+		}
+		sb.WriteString(fmt.Sprintf("%s: <b>%d</b> (✅ %d · ❌ %d)\n", k.label, s.Requests[k.kind], outcomes["ok"], failed))
+		if classes := formatCounts(outcomes, "ok"); classes != "" {
+			sb.WriteString("   <i>" + classes + "</i>\n")
+		}
+	}
+
+	if platforms := formatCounts(s.Platforms, ""); platforms != "" {
+		sb.WriteString("\n<b>🎵 Platforms</b>\n" + platforms + "\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("\n<b>⚡ File cache</b>\nHit rate: <b>%.1f%%</b> (%d hits, %d misses)\n",
+		s.CacheHitRate()*100, s.CacheHits, s.CacheMisses))
+
+	sb.WriteString("\n<b>⏱ Timings</b>\n")
+	for _, p := range statPhases {
+		t, ok := s.Timings[p.phase]
+		if !ok || t.Count == 0 {
+			sb.WriteString(fmt.Sprintf("%s: -\n", p.label))
+			continue
+		}
+		p90 := "over " + utils.TimingBounds[len(utils.TimingBounds)-1].String()
+		if q := t.Quantile(0.9); q >= 0 {
+			p90 = "≤ " + q.String()
+		}
+		sb.WriteString(fmt.Sprintf("%s: avg <b>%s</b> · p90 %s (%d)\n", p.label, t.Average().Round(100*time.Millisecond), p90, t.Count))
+	}
+	return sb.String()
+}
+
+// formatCounts writes counts as "name: n" pairs, largest first, leaving out skip
+func formatCounts(counts map[string]int64, skip string) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		if name != skip {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %d", html.EscapeString(name), counts[name])
+	}
+	return strings.Join(parts, " · ")
+}
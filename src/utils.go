@@ -1,10 +1,21 @@
 package src
 
 import (
+	"errors"
 	"fmt"
 	"github.com/amarnathcjd/gogram/telegram"
+	"log"
 	"os"
+	"regexp"
+	"songBot/src/metrics"
 	"songBot/src/utils"
+	"strconv"
+	"time"
+)
+
+var (
+	errMissingAudio = errors.New("audio file missing")
+	errMissingZip   = errors.New("zip file missing")
 )
 
 func fileExists(path string) bool {
@@ -13,18 +24,78 @@ func fileExists(path string) bool {
 }
 
 // prepareTrackMessageOptions builds SendOptions for sending an audio track.
+// A local file is probed so its MIME type, file name and duration match what it really contains.
 func prepareTrackMessageOptions(file any, thumb any, track *utils.TrackInfo, progress *telegram.ProgressManager) telegram.SendOptions {
-	return telegram.SendOptions{
+	opts := telegram.SendOptions{
 		ProgressManager: progress,
 		Media:           file,
 		Thumb:           thumb,
-		Attributes:      buildAudioAttributes(track),
+		Attributes:      buildAudioAttributes(track, 0),
 		Caption:         buildTrackCaption(track),
 		MimeType:        "audio/mpeg",
-		ReplyMarkup: telegram.NewKeyboard().AddRow(
-			telegram.Button.URL("🎧 Fᴀʟʟᴇɴ Pʀᴏᴊᴇᴄᴛꜱ", "https://t.me/FallenProjects"),
-		).Build(),
+		ReplyMarkup:     trackMarkup(track),
+	}
+
+	// Anything else (e.g. a cached media) already carries its type
+	path, ok := file.(string)
+	if !ok {
+		return opts
+	}
+
+	info, err := utils.ProbeAudio(path)
+	if !info.Known() {
+		// Telegram would show an unplayable audio, a plain document at least downloads fine
+		log.Printf("[Probe] ❌ %s: %v", path, err)
+		opts.Attributes = nil
+		opts.MimeType = "application/octet-stream"
+		opts.ForceDocument = true
+		return opts
+	}
+
+	opts.MimeType = info.MimeType
+	opts.FileName = utils.SanitizeFilename(track.Artist+" - "+track.Name) + info.Ext
+	opts.Attributes = buildAudioAttributes(track, info.Duration)
+	return opts
+}
+
+// uploadTrackMedia uploads the audio file at path, and its thumbnail, as a document described by opts
+func uploadTrackMedia(client *telegram.Client, path string, thumb []byte, opts *telegram.SendOptions) (*telegram.InputMediaUploadedDocument, error) {
+	file, err := client.UploadFile(path, &telegram.UploadOptions{FileName: opts.FileName, ProgressManager: opts.ProgressManager})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload: %w", err)
 	}
+	doc := &telegram.InputMediaUploadedDocument{
+		File:       file,
+		MimeType:   opts.MimeType,
+		Attributes: append(opts.Attributes, &telegram.DocumentAttributeFilename{FileName: opts.FileName}),
+	}
+	if len(thumb) > 0 {
+		if doc.Thumb, err = client.UploadFile(thumb, &telegram.UploadOptions{FileName: "thumb.jpg"}); err != nil {
+			client.Logger.Warn("Failed to upload thumbnail:", err)
+		}
+	}
+	return doc, nil
+}
+
+// trackMarkup is the keyboard under a delivered track: a favorite button, and a lyrics button when
+// its lyrics are known. Both find the track by its TC, remembered once it is delivered; taps on
+// inline messages are handled by the inlinecallback handlers. A nil track leaves them out.
+func trackMarkup(track *utils.TrackInfo) telegram.ReplyMarkup {
+	kb := telegram.NewKeyboard()
+	if track != nil && track.TC != "" {
+		row := []telegram.KeyboardButton{telegram.Button.Data("❤️", "fav_"+track.TC)}
+		hasLyrics := !utils.ParseLyrics(track.Lyrics).Empty()
+		if !hasLyrics {
+			_, hasLyrics = utils.GetLyrics(track.TC)
+		}
+		if hasLyrics {
+			row = append(row, telegram.Button.Data("📝 Lyrics", "lyrics_"+track.TC))
+		}
+		kb.AddRow(row...)
+	}
+	return kb.AddRow(
+		telegram.Button.URL("🎧 Fᴀʟʟᴇɴ Pʀᴏᴊᴇᴄᴛꜱ", "https://t.me/FallenProjects"),
+	).Build()
 }
 
 // buildTrackCaption returns the caption string for a Spotify track.
@@ -33,17 +104,103 @@ func buildTrackCaption(track *utils.TrackInfo) string {
 }
 
 // buildAudioAttributes returns audio metadata for sending audio files.
-func buildAudioAttributes(track *utils.TrackInfo) []telegram.DocumentAttribute {
+// The duration read from the file is preferred over the one reported by the provider.
+func buildAudioAttributes(track *utils.TrackInfo, probed time.Duration) []telegram.DocumentAttribute {
+	duration := int32(track.Duration)
+	if seconds := int32(probed.Round(time.Second) / time.Second); seconds > 0 {
+		duration = seconds
+	}
 	return []telegram.DocumentAttribute{
 		&telegram.DocumentAttributeAudio{
 			Title:     track.Name,
 			Performer: track.Artist,
-			Duration:  int32(track.Duration),
+			Duration:  duration,
 		},
 	}
 }
 
+// telegramLinkRegex matches a message link such as https://t.me/channel/1234
+var telegramLinkRegex = regexp.MustCompile(`https?://t\.me/([^/]+)/(\d+)`)
+
+// downloadTelegramLink downloads the file of a Telegram message link; anything else is returned unchanged
+func downloadTelegramLink(client *telegram.Client, audioFile string) (string, error) {
+	matches := telegramLinkRegex.FindStringSubmatch(audioFile)
+	if len(matches) != 3 {
+		return audioFile, nil
+	}
+	if id, err := strconv.Atoi(matches[2]); err == nil {
+		if ref, err := client.GetMessageByID(matches[1], int32(id)); err == nil {
+			return ref.Download(&telegram.DownloadOptions{FileName: ref.File.Name})
+		}
+	}
+	return audioFile, nil
+}
+
+// botID is the ID of the bot behind client; a file_id only works for the bot that uploaded the file
+func botID(client *telegram.Client) int64 {
+	if me := client.Me(); me != nil {
+		return me.ID
+	}
+	return 0
+}
+
+// sendCachedTrack re-sends an already uploaded track by its file_id.
+// A reference rejected by Telegram is dropped from the cache so the caller can fall back to a fresh download.
+func sendCachedTrack(client *telegram.Client, send func(caption string, opts *telegram.SendOptions) error, keys ...string) (*utils.CachedFile, bool) {
+	entry, ok := utils.GetCachedFile(keys...)
+	if !ok {
+		return nil, false
+	}
+
+	media, err := telegram.ResolveBotFileID(entry.FileID)
+	if err != nil {
+		client.Logger.Warn("Invalid cached file_id:", err)
+		utils.InvalidateCachedFile(entry.FileID)
+		return nil, false
+	}
+
+	track := entry.Track()
+	opts := prepareTrackMessageOptions(media, entry.ThumbData(), track, nil)
+	if err = send(buildTrackCaption(track), &opts); err != nil {
+		client.Logger.Warn("Failed to send cached file:", err)
+		if utils.IsStaleFileError(err) {
+			utils.InvalidateCachedFile(entry.FileID)
+		}
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// downloadProgress reports download progress through edit, at most once every few seconds
+func downloadProgress(edit func(text string)) utils.ProgressFunc {
+	last := time.Now()
+	return func(done, total int64) {
+		if time.Since(last) < 3*time.Second {
+			return
+		}
+		last = time.Now()
+
+		if total > 0 {
+			edit(fmt.Sprintf("⏬ Downloading the song... %d%%", done*100/total))
+		} else {
+			edit(fmt.Sprintf("⏬ Downloading the song... %.1f MB", float64(done)/(1<<20)))
+		}
+	}
+}
+
 func clientSendEditedMessage(client *telegram.Client, msgID any, text string, opts *telegram.SendOptions) error {
 	_, err := client.EditMessage(msgID, 0, text, opts)
 	return err
 }
+
+// HandleFlood waits out a flood wait error and reports whether the request should be retried
+func HandleFlood(err error) bool {
+	if wait := telegram.GetFloodWait(err); wait > 0 {
+		metrics.FloodWaits.Inc()
+		metrics.FloodWaitSeconds.Add(float64(wait))
+		time.Sleep(time.Duration(wait) * time.Second)
+		return true
+	}
+	return false
+}
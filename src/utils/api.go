@@ -1,20 +1,23 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"time"
 
 	"songBot/src/config"
+	"songBot/src/metrics"
 )
 
 // Constants for API configuration and validation
 const (
-	apiTimeout      = 60 * time.Second
 	defaultLimit    = "10"
 	maxQueryLength  = 500
 	maxURLLength    = 5000
@@ -36,6 +39,22 @@ var UrlPatterns = map[string]*regexp.Regexp{
 	"apple_music": regexp.MustCompile(`^(https?://)?([a-z0-9-]+\.)?apple\.com/[a-z]{2}/(album|playlist|song)/[^/]+/(pl\.[a-zA-Z0-9]+|\d+)(\?i=\d+)?(\?.*)?$`),
 }
 
+// URLPlatform returns the platform of a supported URL, or "" for anything else
+func URLPlatform(rawURL string) string {
+	names := make([]string, 0, len(UrlPatterns))
+	for name := range UrlPatterns {
+		names = append(names, name)
+	}
+	// youtube and youtube_music overlap, the shorter name wins
+	sort.Strings(names)
+	for _, name := range names {
+		if UrlPatterns[name].MatchString(rawURL) {
+			return name
+		}
+	}
+	return ""
+}
+
 // ApiData represents a reusable HTTP client for API operations
 type ApiData struct {
 	ApiUrl string
@@ -46,8 +65,8 @@ type ApiData struct {
 // NewApiData creates and returns an ApiData instance
 func NewApiData(query string) *ApiData {
 	return &ApiData{
-		ApiUrl: config.ApiUrl,
-		Client: &http.Client{Timeout: apiTimeout},
+		ApiUrl: config.Cfg.ApiUrl,
+		Client: &http.Client{Timeout: config.Cfg.ApiTimeout},
 		Query:  sanitizeInput(query),
 	}
 }
@@ -89,7 +108,7 @@ func (api *ApiData) FetchData(rawURL string) (*PlatformTracks, error) {
 
 	api.setHeaders(req)
 
-	resp, err := api.Client.Do(req)
+	resp, err := api.do("get_url", req)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
@@ -126,7 +145,7 @@ func (api *ApiData) Search(limit string) (*PlatformTracks, error) {
 
 	api.setHeaders(req)
 
-	resp, err := api.Client.Do(req)
+	resp, err := api.do("search_track", req)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
@@ -159,7 +178,7 @@ func (api *ApiData) GetTrack() (*TrackInfo, error) {
 
 	api.setHeaders(req)
 
-	resp, err := api.Client.Do(req)
+	resp, err := api.do("get_track", req)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
@@ -177,9 +196,49 @@ func (api *ApiData) GetTrack() (*TrackInfo, error) {
 	return &track, nil
 }
 
+// Ping makes the smallest search the API allows, to check that it answers and accepts the key
+func (api *ApiData) Ping(ctx context.Context) error {
+	endpoint := fmt.Sprintf("%s/search_track/%s?lim=1", api.ApiUrl, url.QueryEscape("ping"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("creating request failed: %w", err)
+	}
+
+	api.setHeaders(req)
+
+	resp, err := api.do("ping", req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("API key rejected: status code %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+// do sends a request to the API, recording its duration and status under endpoint
+func (api *ApiData) do(endpoint string, req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := api.Client.Do(req)
+	metrics.APIDuration.Observe(time.Since(started).Seconds(), endpoint)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.APIRequests.Inc(endpoint, status)
+	return resp, err
+}
+
 // setHeaders sets common headers on the HTTP request
 func (api *ApiData) setHeaders(req *http.Request) {
-	req.Header.Set(headerAPIKey, config.ApiKey)
+	req.Header.Set(headerAPIKey, config.Cfg.ApiKey)
 	req.Header.Set(headerAccept, mimeApplication)
 }
 
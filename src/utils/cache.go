@@ -3,37 +3,158 @@ package utils
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"log"
+	"strconv"
 	"sync"
+	"time"
 )
 
 var (
-	urlMap   = make(map[string]string)
-	mu       sync.RWMutex
-	tokenLen = 10
+	tokenLen         = 10
+	maxTokenAttempts = 8
+
+	errTokenNotFound  = errors.New("token not found")
+	errTokenExpired   = errors.New("token expired")
+	errTokenCollision = errors.New("token collision could not be resolved")
 )
 
+// TokenEntry is a URL stored behind a short callback token
+type TokenEntry struct {
+	URL     string `json:"url"`
+	Expires int64  `json:"expires"`
+}
+
+func (e TokenEntry) expired(now time.Time) bool {
+	return e.Expires > 0 && now.Unix() >= e.Expires
+}
+
+// TokenStore keeps the short tokens used in callback data
+type TokenStore interface {
+	// Get returns the entry stored for token, if any
+	Get(token string) (TokenEntry, bool, error)
+	// Put stores or replaces the entry for token
+	Put(token string, entry TokenEntry) error
+	// Sweep removes every entry expired at now and returns how many were removed
+	Sweep(now time.Time) (int, error)
+}
+
+var (
+	tokenStore TokenStore = NewMemoryTokenStore()
+	tokenTTL              = 7 * 24 * time.Hour
+	tokenMu    sync.Mutex
+)
+
+// SetTokenStore replaces the store used by EncodeURL and DecodeURL
+func SetTokenStore(store TokenStore, ttl time.Duration) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	tokenStore = store
+	if ttl > 0 {
+		tokenTTL = ttl
+	}
+}
+
+// tokenLifetime returns how long tokens and the data behind their buttons are kept
+func tokenLifetime() time.Duration {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	return tokenTTL
+}
+
+// StartTokenSweeper periodically removes expired tokens, result pages and delivered tracks
+func StartTokenSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			tokenMu.Lock()
+			store := tokenStore
+			tokenMu.Unlock()
+
+			removed, err := store.Sweep(time.Now())
+			if errors.Is(err, errDBNotOpened) {
+				continue
+			}
+			if err != nil {
+				log.Printf("[Tokens] ❌ Sweep failed: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("[Tokens] Removed %d expired tokens", removed)
+			}
+
+			// Result pages share the lifetime of the tokens behind their buttons
+			if removed, err := sweepResults(time.Now()); err != nil && !errors.Is(err, errDBNotOpened) {
+				log.Printf("[Tokens] ❌ Results sweep failed: %v", err)
+			} else if removed > 0 {
+				log.Printf("[Tokens] Removed %d expired result pages", removed)
+			}
+			if removed, err := sweepTracks(time.Now()); err != nil && !errors.Is(err, errDBNotOpened) {
+				log.Printf("[Tokens] ❌ Tracks sweep failed: %v", err)
+			} else if removed > 0 {
+				log.Printf("[Tokens] Removed %d expired tracks", removed)
+			}
+		}
+	}()
+}
+
 // EncodeURL stores the URL and returns a short token (10 characters)
 func EncodeURL(url string) string {
-	token := generateShortToken(url)
+	token, err := encodeURL(url)
+	if err != nil {
+		log.Printf("[Tokens] ❌ Failed to store token for %q: %v", url, err)
+	}
+	return token
+}
 
-	mu.Lock()
-	urlMap[token] = url
-	mu.Unlock()
+func encodeURL(url string) (string, error) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
 
-	return token
+	now := time.Now()
+	entry := TokenEntry{URL: url, Expires: now.Add(tokenTTL).Unix()}
+
+	// A different, still valid URL behind the same prefix is a collision: re-hash with a salt
+	for attempt := 0; attempt < maxTokenAttempts; attempt++ {
+		seed := url
+		if attempt > 0 {
+			seed = url + "#" + strconv.Itoa(attempt)
+		}
+		token := generateShortToken(seed)
+
+		existing, ok, err := tokenStore.Get(token)
+		if err != nil {
+			return token, err
+		}
+		if ok && existing.URL != url && !existing.expired(now) {
+			continue
+		}
+		return token, tokenStore.Put(token, entry)
+	}
+
+	return generateShortToken(url), errTokenCollision
 }
 
 // DecodeURL retrieves the original URL using the token
 func DecodeURL(token string) (string, error) {
-	mu.RLock()
-	url, ok := urlMap[token]
-	mu.RUnlock()
+	tokenMu.Lock()
+	store := tokenStore
+	tokenMu.Unlock()
 
+	entry, ok, err := store.Get(token)
+	if err != nil {
+		return "", err
+	}
 	if !ok {
-		return "", errors.New("token not found")
+		return "", errTokenNotFound
+	}
+	if entry.expired(time.Now()) {
+		return "", errTokenExpired
 	}
-	return url, nil
+	return entry.URL, nil
 }
 
 // generateShortToken creates a consistent 10-character hash from the URL
@@ -41,3 +162,70 @@ func generateShortToken(url string) string {
 	hash := sha256.Sum256([]byte(url))
 	return hex.EncodeToString(hash[:])[:tokenLen]
 }
+
+// MemoryTokenStore keeps tokens in memory; they are lost on restart
+type MemoryTokenStore struct {
+	mu      sync.RWMutex
+	entries map[string]TokenEntry
+}
+
+// NewMemoryTokenStore creates an empty in-memory token store
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{entries: make(map[string]TokenEntry)}
+}
+
+func (s *MemoryTokenStore) Get(token string) (TokenEntry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[token]
+	return entry, ok, nil
+}
+
+func (s *MemoryTokenStore) Put(token string, entry TokenEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[token] = entry
+	return nil
+}
+
+func (s *MemoryTokenStore) Sweep(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for token, entry := range s.entries {
+		if entry.expired(now) {
+			delete(s.entries, token)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// BoltTokenStore keeps tokens in the embedded database so they survive restarts
+type BoltTokenStore struct{}
+
+// NewBoltTokenStore returns a token store backed by the database opened with OpenDB
+func NewBoltTokenStore() *BoltTokenStore {
+	return &BoltTokenStore{}
+}
+
+func (s *BoltTokenStore) Get(token string) (TokenEntry, bool, error) {
+	var entry TokenEntry
+	ok, err := dbGet(bucketTokens, token, &entry)
+	return entry, ok, err
+}
+
+func (s *BoltTokenStore) Put(token string, entry TokenEntry) error {
+	return dbPut(bucketTokens, token, entry)
+}
+
+func (s *BoltTokenStore) Sweep(now time.Time) (int, error) {
+	return dbDeleteWhere(bucketTokens, func(_, v []byte) bool {
+		var entry TokenEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return true
+		}
+		return entry.expired(now)
+	})
+}
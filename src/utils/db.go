@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the embedded database
+var (
+	bucketTokens    = []byte("tokens")
+	bucketFiles     = []byte("files")
+	bucketFormats   = []byte("formats")
+	bucketResults   = []byte("results")
+	bucketTracks    = []byte("tracks")
+	bucketHistory   = []byte("history")
+	bucketFavorites = []byte("favorites")
+	bucketStats     = []byte("stats")
+
+	buckets = [][]byte{bucketTokens, bucketFiles, bucketFormats, bucketResults, bucketTracks, bucketHistory, bucketFavorites, bucketStats}
+)
+
+var (
+	// db is guarded by dbMu: the sweepers, the stats flusher and late handlers may still use it while
+	// CloseDB runs, so every access holds the read lock and closing waits for them
+	db             *bolt.DB
+	dbMu           sync.RWMutex
+	errDBNotOpened = errors.New("database is not opened")
+)
+
+// OpenDB opens (or creates) the embedded database and makes sure every bucket exists
+func OpenDB(path string) error {
+	handle, err := bolt.Open(path, defaultFilePerm, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open database %q: %w", path, err)
+	}
+
+	err = handle.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("failed to create bucket %q: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = handle.Close()
+		return err
+	}
+
+	dbMu.Lock()
+	db = handle
+	dbMu.Unlock()
+	return nil
+}
+
+// CloseDB flushes and closes the embedded database
+func CloseDB() error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if db == nil {
+		return nil
+	}
+	err := db.Close()
+	db = nil
+	return err
+}
+
+// dbUpdate runs fn in a read-write transaction of the open database
+func dbUpdate(fn func(tx *bolt.Tx) error) error {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	if db == nil {
+		return errDBNotOpened
+	}
+	return db.Update(fn)
+}
+
+// dbView runs fn in a read-only transaction of the open database
+func dbView(fn func(tx *bolt.Tx) error) error {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	if db == nil {
+		return errDBNotOpened
+	}
+	return db.View(fn)
+}
+
+// dbPut stores v as JSON under key in the given bucket
+func dbPut(bucket []byte, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %q: %w", key, err)
+	}
+
+	return dbUpdate(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+// dbGet decodes the JSON value stored under key into v and reports whether it was found
+func dbGet(bucket []byte, key string, v any) (bool, error) {
+	var data []byte
+	err := dbView(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(bucket).Get([]byte(key)); raw != nil {
+			data = append([]byte(nil), raw...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to decode %q: %w", key, err)
+	}
+	return true, nil
+}
+
+// dbDelete removes key from the given bucket
+func dbDelete(bucket []byte, key string) error {
+	return dbUpdate(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+// dbDeleteWhere removes every key of the bucket for which match returns true
+func dbDeleteWhere(bucket []byte, match func(k, v []byte) bool) (int, error) {
+	removed := 0
+	err := dbUpdate(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.First(); k != nil; {
+			if !match(k, v) {
+				k, v = c.Next()
+				continue
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			removed++
+			// Delete moves the cursor to the next item
+			k, v = c.Seek(k)
+		}
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fakeFixtureFile optionally sits next to the audio files and overrides their metadata
+const fakeFixtureFile = "tracks.json"
+
+var (
+	errTrackNotFound = errors.New("track not found")
+	audioExtensions  = map[string]bool{".ogg": true, ".opus": true, ".mp3": true, ".m4a": true, ".flac": true, ".wav": true}
+)
+
+// FakeProvider serves the audio files of a local directory without any network access.
+// Every audio file is a track named after its file ("Artist - Title.mp3"); a tracks.json
+// fixture in the same directory can add album, year, duration, cover and lyrics.
+type FakeProvider struct {
+	dir    string // absolute, with symlinks resolved
+	tracks []TrackInfo
+	byID   map[string]int
+}
+
+// fakeFixture is one entry of tracks.json, keyed by the audio file name
+type fakeFixture struct {
+	File     string `json:"file"`
+	Name     string `json:"name"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	Year     int    `json:"year"`
+	Duration int    `json:"duration"`
+	Cover    string `json:"cover"`
+	Lyrics   string `json:"lyrics"`
+}
+
+// NewFakeProvider loads the tracks of dir
+func NewFakeProvider(dir string) (*FakeProvider, error) {
+	dir, err := filepath.Abs(dir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid fixture directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture directory: %w", err)
+	}
+
+	fixtures, err := readFakeFixtures(filepath.Join(dir, fakeFixtureFile))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &FakeProvider{dir: dir, byID: make(map[string]int)}
+	for _, entry := range entries {
+		if entry.IsDir() || !audioExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			continue
+		}
+
+		track := fakeTrack(dir, entry.Name(), fixtures[entry.Name()])
+		p.byID[track.TC] = len(p.tracks)
+		p.tracks = append(p.tracks, track)
+	}
+
+	if len(p.tracks) == 0 {
+		return nil, fmt.Errorf("no audio files in %s", dir)
+	}
+	return p, nil
+}
+
+func readFakeFixtures(path string) (map[string]fakeFixture, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", fakeFixtureFile, err)
+	}
+
+	var list []fakeFixture
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", fakeFixtureFile, err)
+	}
+
+	fixtures := make(map[string]fakeFixture, len(list))
+	for _, f := range list {
+		fixtures[f.File] = f
+	}
+	return fixtures, nil
+}
+
+// fakeTrack builds the track of an audio file, preferring the fixture metadata
+func fakeTrack(dir, name string, fixture fakeFixture) TrackInfo {
+	path := filepath.Join(dir, name)
+	sum := sha256.Sum256([]byte(name))
+
+	track := TrackInfo{
+		CdnURL:   fileURL(path),
+		Name:     strings.TrimSuffix(name, filepath.Ext(name)),
+		Artist:   "Unknown Artist",
+		TC:       "local" + hex.EncodeToString(sum[:6]),
+		Album:    fixture.Album,
+		Year:     fixture.Year,
+		Duration: fixture.Duration,
+		Lyrics:   fixture.Lyrics,
+		Platform: "local",
+	}
+
+	if artist, title, ok := strings.Cut(track.Name, " - "); ok {
+		track.Artist, track.Name = strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	if fixture.Name != "" {
+		track.Name = fixture.Name
+	}
+	if fixture.Artist != "" {
+		track.Artist = fixture.Artist
+	}
+	if fixture.Cover != "" {
+		track.Cover = fileURL(filepath.Join(dir, fixture.Cover))
+	}
+	return track
+}
+
+// resolve returns path with symlinks resolved when it is inside the fixture directory
+func (p *FakeProvider) resolve(path string) (string, bool) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(p.dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", false
+	}
+	return resolved, true
+}
+
+func fileURL(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func (p *FakeProvider) musicTrack(track TrackInfo) MusicTrack {
+	year := ""
+	if track.Year > 0 {
+		year = strconv.Itoa(track.Year)
+	}
+	return MusicTrack{
+		Name:     track.Name,
+		Artist:   track.Artist,
+		ID:       track.TC,
+		URL:      track.CdnURL,
+		Year:     year,
+		Cover:    track.Cover,
+		Duration: track.Duration,
+		Platform: track.Platform,
+	}
+}
+
+// Search matches every word of the query against the name, artist and album of the tracks
+func (p *FakeProvider) Search(query string, limit int) (*PlatformTracks, error) {
+	words := strings.Fields(strings.ToLower(query))
+	result := &PlatformTracks{}
+	for _, track := range p.tracks {
+		haystack := strings.ToLower(track.Name + " " + track.Artist + " " + track.Album)
+		matched := true
+		for _, word := range words {
+			if !strings.Contains(haystack, word) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			result.Results = append(result.Results, p.musicTrack(track))
+		}
+	}
+
+	sort.SliceStable(result.Results, func(i, j int) bool { return result.Results[i].Name < result.Results[j].Name })
+	if limit > 0 && len(result.Results) > limit {
+		result.Results = result.Results[:limit]
+	}
+	return result, nil
+}
+
+// GetInfo returns the track behind a file:// URL; the fixture directory itself lists every track
+func (p *FakeProvider) GetInfo(rawURL string) (*PlatformTracks, error) {
+	if track, err := p.GetTrack(rawURL); err == nil {
+		return &PlatformTracks{Results: []MusicTrack{p.musicTrack(*track)}}, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" {
+		return nil, errors.New("invalid or unsupported URL")
+	}
+
+	result := &PlatformTracks{}
+	prefix := strings.TrimSuffix(u.String(), "/") + "/"
+	for _, track := range p.tracks {
+		if strings.HasPrefix(track.CdnURL, prefix) {
+			result.Results = append(result.Results, p.musicTrack(track))
+		}
+	}
+	if len(result.Results) == 0 {
+		return nil, errTrackNotFound
+	}
+	return result, nil
+}
+
+// GetTrack looks a track up by its ID or file:// URL
+func (p *FakeProvider) GetTrack(id string) (*TrackInfo, error) {
+	if i, ok := p.byID[id]; ok {
+		track := p.tracks[i]
+		return &track, nil
+	}
+
+	for _, track := range p.tracks {
+		if track.CdnURL == id {
+			return &track, nil
+		}
+	}
+	return nil, errTrackNotFound
+}
+
+// Ping checks that the first track can still be read
+func (p *FakeProvider) Ping(context.Context) error {
+	path, _, err := localPath(p.tracks[0].CdnURL)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(path)
+	return err
+}
+
+func (p *FakeProvider) Resolve(query string, limit int) (*PlatformTracks, bool, error) {
+	if strings.HasPrefix(query, "file://") {
+		tracks, err := p.GetInfo(query)
+		return tracks, true, err
+	}
+	tracks, err := p.Search(query, limit)
+	return tracks, false, err
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"songBot/src/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errFavoritesFull = errors.New("favorites are full")
+
+// Favorite is a track a user saved with the ❤️ button
+type Favorite struct {
+	TrackID  string `json:"track_id"` // TC of the track
+	Source   string `json:"source"`   // URL or ID the track is fetched with
+	Name     string `json:"name"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album,omitempty"`
+	Platform string `json:"platform,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+	Added    int64  `json:"added"`
+}
+
+// Track returns the favorite as a playlist track, as ZipTracks takes them
+func (f *Favorite) Track() MusicTrack {
+	return MusicTrack{
+		ID:       f.TrackID,
+		URL:      f.Source,
+		Name:     f.Name,
+		Artist:   f.Artist,
+		Duration: f.Duration,
+		Platform: f.Platform,
+	}
+}
+
+// favoritesMu serializes the read-modify-write of favorite lists
+var favoritesMu sync.Mutex
+
+// GetFavorites returns the favorites of a user, newest first
+func GetFavorites(userID int64) ([]Favorite, error) {
+	var favorites []Favorite
+	if _, err := dbGet(bucketFavorites, strconv.FormatInt(userID, 10), &favorites); err != nil {
+		return nil, err
+	}
+	return favorites, nil
+}
+
+// ToggleFavorite adds a delivered track to the favorites of a user, or removes it when it is
+// already there, and reports whether it was added
+func ToggleFavorite(userID int64, tc string) (bool, error) {
+	stored, ok := GetStoredTrack(tc)
+	if !ok {
+		return false, fmt.Errorf("track %s: %w", tc, errTrackNotFound)
+	}
+
+	favoritesMu.Lock()
+	defer favoritesMu.Unlock()
+
+	favorites, err := GetFavorites(userID)
+	if err != nil {
+		return false, err
+	}
+
+	key := strconv.FormatInt(userID, 10)
+	for i, f := range favorites {
+		if f.TrackID == tc {
+			favorites = append(favorites[:i], favorites[i+1:]...)
+			if len(favorites) == 0 {
+				return false, dbDelete(bucketFavorites, key)
+			}
+			return false, dbPut(bucketFavorites, key, favorites)
+		}
+	}
+
+	if len(favorites) >= config.Cfg.FavoritesLimit {
+		return false, fmt.Errorf("%w (%d tracks)", errFavoritesFull, config.Cfg.FavoritesLimit)
+	}
+
+	source := stored.Source
+	if source == "" {
+		// Providers also fetch a track by its ID
+		source = tc
+	}
+	favorites = append([]Favorite{{
+		TrackID:  tc,
+		Source:   source,
+		Name:     stored.Name,
+		Artist:   stored.Artist,
+		Album:    stored.Album,
+		Platform: stored.Platform,
+		Duration: stored.Duration,
+		Added:    time.Now().Unix(),
+	}}, favorites...)
+	return true, dbPut(bucketFavorites, key, favorites)
+}
+
+// IsFavoritesFull reports whether err is ToggleFavorite refusing a track over the limit
+func IsFavoritesFull(err error) bool {
+	return errors.Is(err, errFavoritesFull)
+}
+
+// FavoritesJSON exports favorites as an indented JSON array
+func FavoritesJSON(favorites []Favorite) ([]byte, error) {
+	return json.MarshalIndent(favorites, "", "  ")
+}
+
+// FavoritesM3U exports favorites as an extended M3U playlist pointing at their sources
+func FavoritesM3U(favorites []Favorite) string {
+	var sb strings.Builder
+	sb.WriteString(m3uHeader)
+	for _, f := range favorites {
+		duration := f.Duration
+		if duration <= 0 {
+			duration = -1
+		}
+		sb.WriteString(fmt.Sprintf("#EXTINF:%d,%s - %s\n%s\n", duration, f.Artist, f.Name, f.Source))
+	}
+	return sb.String()
+}
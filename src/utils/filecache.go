@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"songBot/src/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachedFile is a track that was already uploaded to Telegram and can be re-sent by its file_id
+type CachedFile struct {
+	FileID   string `json:"file_id"`
+	Thumb    string `json:"thumb"` // path of the thumbnail uploaded with the file, empty without one
+	Cover    string `json:"cover"`
+	Name     string `json:"name"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	TC       string `json:"tc"`
+	Year     int    `json:"year"`
+	Duration int    `json:"duration"`
+	Platform string `json:"platform"`
+	CachedAt int64  `json:"cached_at"`
+}
+
+// NewCachedFile builds a cache entry for a track delivered as fileID with the thumbnail thumb, which is
+// kept in the downloads directory
+func NewCachedFile(track *TrackInfo, fileID string, thumb []byte) CachedFile {
+	return CachedFile{
+		FileID:   fileID,
+		Thumb:    saveThumb(track.TC, thumb),
+		Cover:    track.Cover,
+		Name:     track.Name,
+		Artist:   track.Artist,
+		Album:    track.Album,
+		TC:       track.TC,
+		Year:     track.Year,
+		Duration: track.Duration,
+		Platform: track.Platform,
+		CachedAt: time.Now().Unix(),
+	}
+}
+
+// Track returns the track metadata stored with the entry
+func (c *CachedFile) Track() *TrackInfo {
+	return &TrackInfo{
+		Name:     c.Name,
+		Artist:   c.Artist,
+		TC:       c.TC,
+		Cover:    c.Cover,
+		Album:    c.Album,
+		Year:     c.Year,
+		Duration: c.Duration,
+		Platform: c.Platform,
+	}
+}
+
+// ThumbData returns the thumbnail of the entry, nil when it has none or the janitor evicted it
+func (c *CachedFile) ThumbData() []byte {
+	if c.Thumb == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.Thumb)
+	if err != nil {
+		return nil
+	}
+	touchFile(c.Thumb)
+	return data
+}
+
+// saveThumb writes the thumbnail of a track next to its download and returns the path, or "" when
+// there is nothing to keep
+func saveThumb(tc string, thumb []byte) string {
+	if tc == "" || len(thumb) == 0 {
+		return ""
+	}
+
+	path := filepath.Join(config.Cfg.DownloadPath, SanitizeFilename(tc)+".thumb.jpg")
+	if _, err := os.Stat(path); err == nil {
+		touchFile(path)
+		return path
+	}
+	if err := os.WriteFile(path, thumb, defaultFilePerm); err != nil {
+		log.Printf("[FileCache] ❌ Failed to keep the thumbnail of %s: %v", tc, err)
+		return ""
+	}
+	return path
+}
+
+// TrackCacheKey is the cache key of a track by its ID or TC. A file_id only works for the bot that
+// uploaded the file, so every bot has its own keys.
+func TrackCacheKey(botID int64, id string) string {
+	return strconv.FormatInt(botID, 10) + ":track:" + id
+}
+
+// URLCacheKey is the cache key of a track by its canonical URL, for the bot botID
+func URLCacheKey(botID int64, rawURL string) string {
+	return strconv.FormatInt(botID, 10) + ":url:" + CanonicalURL(rawURL)
+}
+
+// CanonicalURL normalizes a track URL so that equivalent links share one cache entry
+func CanonicalURL(rawURL string) string {
+	raw := strings.TrimSpace(rawURL)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(rawURL)
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	query := ""
+	// YouTube keeps the video in the query string, every other platform only has tracking params there
+	if v := u.Query().Get("v"); v != "" {
+		query = "?v=" + v
+	}
+
+	return "https://" + host + strings.TrimSuffix(u.Path, "/") + query
+}
+
+// GetCachedFile returns the first cached upload found under any of keys
+func GetCachedFile(keys ...string) (*CachedFile, bool) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		var entry CachedFile
+		ok, err := dbGet(bucketFiles, key, &entry)
+		if err != nil {
+			log.Printf("[FileCache] ❌ Lookup of %q failed: %v", key, err)
+			continue
+		}
+		if ok && entry.FileID != "" {
+			return &entry, true
+		}
+	}
+	return nil, false
+}
+
+// StoreCachedFile records entry under every key
+func StoreCachedFile(entry CachedFile, keys ...string) {
+	if entry.FileID == "" {
+		return
+	}
+
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := dbPut(bucketFiles, key, entry); err != nil {
+			log.Printf("[FileCache] ❌ Failed to store %q: %v", key, err)
+		}
+	}
+}
+
+// InvalidateCachedFile drops every key pointing at fileID, e.g. after Telegram rejected the reference
+func InvalidateCachedFile(fileID string) {
+	removed, err := dbDeleteWhere(bucketFiles, func(_, v []byte) bool {
+		var entry CachedFile
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return true
+		}
+		return entry.FileID == fileID
+	})
+	if err != nil {
+		log.Printf("[FileCache] ❌ Failed to invalidate %q: %v", fileID, err)
+		return
+	}
+	log.Printf("[FileCache] Invalidated %d entries for a stale file reference", removed)
+}
+
+// IsStaleFileError reports whether Telegram refused the file it was given: an expired or unknown
+// file_id, or MEDIA_EMPTY when it could not use the file at all. A cached file_id that fails this way
+// is dropped; a fresh upload that fails this way may be uploaded again.
+func IsStaleFileError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	for _, code := range []string{"FILE_REFERENCE_", "FILE_ID_INVALID", "MEDIA_EMPTY", "DOCUMENT_INVALID"} {
+		if strings.Contains(msg, code) {
+			return true
+		}
+	}
+	return false
+}
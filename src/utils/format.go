@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"songBot/src/metrics"
+	"songBot/src/ogg"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AudioFormat is the codec and bitrate tracks are delivered in; the zero value keeps the source format
+type AudioFormat struct {
+	Codec   string `json:"codec"`
+	Bitrate int    `json:"bitrate"` // kbps, ignored by lossless codecs
+}
+
+// codec describes how ffmpeg produces one output format
+type codec struct {
+	label    string
+	ext      string
+	encoder  string
+	bitrates []int // allowed bitrates, the first is the default; empty for lossless codecs
+	coverArt bool  // whether the container takes the cover as an attached picture stream
+}
+
+// Codecs in the order they are offered to users
+var (
+	CodecNames = []string{"mp3", "opus", "flac", "m4a"}
+
+	codecs = map[string]codec{
+		"mp3":  {label: "MP3", ext: ".mp3", encoder: "libmp3lame", bitrates: []int{320, 256, 192, 128}, coverArt: true},
+		"opus": {label: "Opus", ext: ".opus", encoder: "libopus", bitrates: []int{160, 128, 96, 64}},
+		"flac": {label: "FLAC", ext: ".flac", encoder: "flac", coverArt: true},
+		"m4a":  {label: "M4A", ext: ".m4a", encoder: "aac", bitrates: []int{256, 192, 128}, coverArt: true},
+	}
+
+	errUnknownFormat = errors.New("unknown format")
+)
+
+// ParseFormat parses "original", "mp3", "mp3-192" or "mp3:192"; a missing bitrate means the codec default
+func ParseFormat(s string) (AudioFormat, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "original" {
+		return AudioFormat{}, nil
+	}
+
+	name, rate, hasRate := strings.Cut(strings.Replace(s, ":", "-", 1), "-")
+	c, ok := codecs[name]
+	if !ok {
+		return AudioFormat{}, fmt.Errorf("%w %q", errUnknownFormat, s)
+	}
+
+	f := AudioFormat{Codec: name}
+	if len(c.bitrates) == 0 {
+		return f, nil
+	}
+
+	f.Bitrate = c.bitrates[0]
+	if hasRate {
+		n, err := strconv.Atoi(strings.TrimSuffix(rate, "k"))
+		if err != nil || !containsInt(c.bitrates, n) {
+			return AudioFormat{}, fmt.Errorf("%w: %s supports %v kbps", errUnknownFormat, c.label, c.bitrates)
+		}
+		f.Bitrate = n
+	}
+	return f, nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Bitrates lists the bitrates offered for a codec, best first
+func Bitrates(codecName string) []int {
+	return codecs[codecName].bitrates
+}
+
+// IsOriginal reports whether tracks are sent as downloaded, without transcoding
+func (f AudioFormat) IsOriginal() bool {
+	return f.Codec == ""
+}
+
+// String is the inverse of ParseFormat, e.g. "mp3-320"
+func (f AudioFormat) String() string {
+	switch {
+	case f.IsOriginal():
+		return "original"
+	case f.Bitrate > 0:
+		return fmt.Sprintf("%s-%d", f.Codec, f.Bitrate)
+	default:
+		return f.Codec
+	}
+}
+
+// Label is the human readable name of the format, e.g. "MP3 320 kbps"
+func (f AudioFormat) Label() string {
+	switch {
+	case f.IsOriginal():
+		return "Original"
+	case f.Bitrate > 0:
+		return fmt.Sprintf("%s %d kbps", codecs[f.Codec].label, f.Bitrate)
+	default:
+		return codecs[f.Codec].label
+	}
+}
+
+// CodecLabel is the human readable name of a codec
+func CodecLabel(codecName string) string {
+	return codecs[codecName].label
+}
+
+// FormatCacheKey returns the cache key of the format variant of a track; the original keeps the plain key
+func FormatCacheKey(key string, f AudioFormat) string {
+	if f.IsOriginal() {
+		return key
+	}
+	return key + "@" + f.String()
+}
+
+// GetUserFormat returns the format a user chose with /format, or the original format
+func GetUserFormat(userID int64) AudioFormat {
+	var f AudioFormat
+	if _, err := dbGet(bucketFormats, strconv.FormatInt(userID, 10), &f); err != nil {
+		return AudioFormat{}
+	}
+	if _, ok := codecs[f.Codec]; !ok {
+		return AudioFormat{}
+	}
+	return f
+}
+
+// SetUserFormat stores the format a user wants their tracks in
+func SetUserFormat(userID int64, f AudioFormat) error {
+	key := strconv.FormatInt(userID, 10)
+	if f.IsOriginal() {
+		return dbDelete(bucketFormats, key)
+	}
+	return dbPut(bucketFormats, key, f)
+}
+
+// transcode converts the audio file at src into f with ffmpeg, carrying over the tags and the cover.
+// The output sits next to src and is reused when it already exists.
+func transcode(ctx context.Context, src string, f AudioFormat, track TrackInfo, cover []byte) (string, error) {
+	c, ok := codecs[f.Codec]
+	if !ok {
+		return "", fmt.Errorf("%w %q", errUnknownFormat, f.Codec)
+	}
+
+	dst := strings.TrimSuffix(src, filepath.Ext(src)) + "." + f.String() + c.ext
+	holdFile(ctx, dst)
+	if _, err := os.Stat(dst); err == nil {
+		touchFile(dst)
+		return dst, nil
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", src}
+	if c.coverArt && len(cover) > 0 {
+		coverPath, err := tempPathFor(dst, ".cover.jpg")
+		if err != nil {
+			return "", fmt.Errorf("failed to create cover file: %w", err)
+		}
+		defer func() {
+			_ = os.Remove(coverPath)
+		}()
+		if err := os.WriteFile(coverPath, cover, defaultFilePerm); err != nil {
+			return "", fmt.Errorf("failed to write cover: %w", err)
+		}
+		args = append(args, "-i", coverPath, "-map", "0:a", "-map", "1:v", "-c:v", "copy", "-disposition:v", "attached_pic")
+	} else {
+		args = append(args, "-map", "0:a")
+	}
+
+	args = append(args, "-map_metadata", "0", "-c:a", c.encoder)
+	if f.Bitrate > 0 {
+		args = append(args, "-b:a", fmt.Sprintf("%dk", f.Bitrate))
+	}
+	if f.Codec == "mp3" {
+		args = append(args, "-id3v2_version", "3")
+	}
+	for _, tag := range [][2]string{
+		{"title", track.Name},
+		{"artist", track.Artist},
+		{"album", track.Album},
+		{"date", strconv.Itoa(track.Year)},
+		{"lyrics", ParseLyrics(track.Lyrics).Tag(&track)},
+	} {
+		if tag[1] != "" && tag[1] != "0" {
+			args = append(args, "-metadata", tag[0]+"="+tag[1])
+		}
+	}
+
+	tempPath, err := tempPathFor(dst, ".part"+c.ext)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tempPath)
+	}()
+	args = append(args, tempPath)
+
+	started := time.Now()
+	out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	metrics.SubprocessDuration.Observe(time.Since(started).Seconds(), "ffmpeg")
+	if err != nil {
+		metrics.SubprocessFailures.Inc("ffmpeg")
+		return "", fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// Ogg has no attached picture stream, Opus keeps the cover in its comment header like Vorbis
+	if f.Codec == "opus" {
+		if err := ogg.UpdateComments(tempPath, trackComments(track, cover)); err != nil {
+			return "", fmt.Errorf("failed to tag opus file: %w", err)
+		}
+	}
+
+	if err := os.Rename(tempPath, dst); err != nil {
+		return "", fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return dst, nil
+}
+
+// tempPathFor creates an empty file next to dst whose name ends with suffix, so that concurrent jobs
+// converting the same track never write to the same file
+func tempPathFor(dst, suffix string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*"+suffix)
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"log"
+	"songBot/src/config"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HistoryEntry is a track delivered to a user
+type HistoryEntry struct {
+	ID       int    `json:"id"`       // stable within the user's history, used by the re-send buttons
+	TrackID  string `json:"track_id"` // TC of the track
+	Source   string `json:"source"`   // URL or ID the track was fetched with, to download it again
+	Name     string `json:"name"`
+	Artist   string `json:"artist"`
+	Platform string `json:"platform"`
+	FileID   string `json:"file_id"` // empty when Telegram didn't tell (inline messages)
+	BotID    int64  `json:"bot_id"`  // bot that uploaded FileID, the only one that can send it
+	Time     int64  `json:"time"`
+}
+
+// Track returns the track metadata stored with the entry
+func (e *HistoryEntry) Track() *TrackInfo {
+	return &TrackInfo{Name: e.Name, Artist: e.Artist, TC: e.TrackID, Platform: e.Platform}
+}
+
+// UserHistory is the download history of one user, newest first. Nothing is recorded until the user opts in.
+type UserHistory struct {
+	Enabled bool           `json:"enabled"`
+	NextID  int            `json:"next_id"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// Page returns the entries of the given page (0-based) and the number of pages; an out of range page is clamped
+func (h *UserHistory) Page(page, perPage int) ([]HistoryEntry, int, int) {
+	pages := max(1, (len(h.Entries)+perPage-1)/perPage)
+	page = min(max(page, 0), pages-1)
+	start := page * perPage
+	end := min(start+perPage, len(h.Entries))
+	return h.Entries[start:end], page, pages
+}
+
+// Find returns the entry with the given ID
+func (h *UserHistory) Find(id int) (*HistoryEntry, bool) {
+	for i := range h.Entries {
+		if h.Entries[i].ID == id {
+			return &h.Entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// historyMu serializes the read-modify-write of history records
+var historyMu sync.Mutex
+
+// GetHistory returns the history of a user; an unknown user has an empty, disabled one
+func GetHistory(userID int64) (*UserHistory, error) {
+	var h UserHistory
+	if _, err := dbGet(bucketHistory, strconv.FormatInt(userID, 10), &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// SetHistoryEnabled opts a user in or out of the history; opting out deletes everything recorded
+func SetHistoryEnabled(userID int64, enabled bool) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	key := strconv.FormatInt(userID, 10)
+	if !enabled {
+		return dbDelete(bucketHistory, key)
+	}
+
+	h, err := GetHistory(userID)
+	if err != nil {
+		return err
+	}
+	h.Enabled = true
+	return dbPut(bucketHistory, key, h)
+}
+
+// ClearHistory deletes the recorded tracks of a user, who stays opted in
+func ClearHistory(userID int64) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	h, err := GetHistory(userID)
+	if err != nil || !h.Enabled {
+		return err
+	}
+	h.Entries = nil
+	return dbPut(bucketHistory, strconv.FormatInt(userID, 10), h)
+}
+
+// AddHistory records a track the bot botID delivered to a user who opted in, when history is enabled.
+// A track already in the history moves to the top; the oldest entries beyond the limit are dropped.
+func AddHistory(userID, botID int64, track *TrackInfo, source, fileID string) {
+	if !config.Cfg.HistoryEnabled || userID == 0 {
+		return
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	h, err := GetHistory(userID)
+	if err != nil {
+		log.Printf("[History] ❌ Failed to load the history of %d: %v", userID, err)
+		return
+	}
+	if !h.Enabled {
+		return
+	}
+
+	entries := make([]HistoryEntry, 0, len(h.Entries)+1)
+	entries = append(entries, HistoryEntry{
+		ID:       h.NextID,
+		TrackID:  track.TC,
+		Source:   source,
+		Name:     track.Name,
+		Artist:   track.Artist,
+		Platform: track.Platform,
+		FileID:   fileID,
+		BotID:    botID,
+		Time:     time.Now().Unix(),
+	})
+	for _, e := range h.Entries {
+		if track.TC == "" || e.TrackID != track.TC {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) > config.Cfg.HistoryLimit {
+		entries = entries[:config.Cfg.HistoryLimit]
+	}
+
+	h.NextID++
+	h.Entries = entries
+	if err := dbPut(bucketHistory, strconv.FormatInt(userID, 10), h); err != nil {
+		log.Printf("[History] ❌ Failed to record a track for %d: %v", userID, err)
+	}
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"songBot/src/config"
+)
+
+// staleTempAge is how long a partial download or scratch file may sit untouched before the
+// janitor removes it
+const staleTempAge = time.Hour
+
+// quotaLowWater is the share of the quota the janitor evicts down to, so it does not evict on every run
+const quotaLowWater = 0.9
+
+// DiskUsage is what the downloads directory holds, and what the last sweep removed from it
+type DiskUsage struct {
+	Files     int   // cached tracks and other finished files
+	Bytes     int64 // size of Files
+	TempFiles int   // partial downloads and scratch files
+	TempBytes int64
+	Held      int       // files in use by a running job
+	Oldest    time.Time // last use of the least recently used file
+
+	Evicted      int // cached files removed to stay under the quota
+	EvictedBytes int64
+	RemovedTemp  int // stale partial downloads and scratch files removed
+	SweptAt      time.Time
+}
+
+var (
+	heldFiles   = make(map[string]int)
+	heldFilesMu sync.Mutex
+
+	lastSweep   DiskUsage
+	lastSweepMu sync.Mutex
+)
+
+// holdFile keeps the janitor away from path until ctx is done, which for a job is when it finishes.
+// Take it before looking for an existing file, so the janitor cannot evict it in between, and on the
+// job's context rather than a per-request timeout that ends as soon as the download returns.
+func holdFile(ctx context.Context, path string) {
+	if path == "" {
+		return
+	}
+	path = filepath.Clean(path)
+
+	heldFilesMu.Lock()
+	heldFiles[path]++
+	heldFilesMu.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		heldFilesMu.Lock()
+		defer heldFilesMu.Unlock()
+		if heldFiles[path]--; heldFiles[path] <= 0 {
+			delete(heldFiles, path)
+		}
+	})
+}
+
+func isHeld(path string) bool {
+	heldFilesMu.Lock()
+	defer heldFilesMu.Unlock()
+	return heldFiles[filepath.Clean(path)] > 0
+}
+
+// touchFile marks a cached file as just used, which keeps it from being evicted first
+func touchFile(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// downloadFileInfo is a file of the downloads directory
+type downloadFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+	temp    bool
+	held    bool
+	removed bool
+}
+
+// scanDownloads lists the regular files under dir
+func scanDownloads(dir string) ([]downloadFileInfo, DiskUsage, error) {
+	var files []downloadFileInfo
+	var usage DiskUsage
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // removed while walking
+		} else if err != nil {
+			return err
+		}
+
+		f := downloadFileInfo{path: path, size: info.Size(), modTime: info.ModTime(), temp: isTempFile(d.Name()), held: isHeld(path)}
+		files = append(files, f)
+		if f.held {
+			usage.Held++
+		}
+		if f.temp {
+			usage.TempFiles++
+			usage.TempBytes += f.size
+			return nil
+		}
+		usage.Files++
+		usage.Bytes += f.size
+		if usage.Oldest.IsZero() || f.modTime.Before(usage.Oldest) {
+			usage.Oldest = f.modTime
+		}
+		return nil
+	})
+	return files, usage, err
+}
+
+// ScanDownloads reports the usage of dir without changing it
+func ScanDownloads(dir string) (DiskUsage, error) {
+	_, usage, err := scanDownloads(dir)
+	return usage, err
+}
+
+// SweepDownloads removes the partial downloads and scratch files under dir untouched for staleAfter,
+// then, when the rest takes more than quota bytes, evicts the least recently used files down to 90%
+// of it. Files held by a running job are never removed.
+func SweepDownloads(dir string, quota int64, staleAfter time.Duration) (DiskUsage, error) {
+	files, usage, err := scanDownloads(dir)
+	if err != nil {
+		return usage, err
+	}
+
+	var errs []error
+	remove := func(f downloadFileInfo) bool {
+		// A job may have taken the file since the scan; holding the lock keeps it from doing so
+		// between the check and the removal
+		heldFilesMu.Lock()
+		defer heldFilesMu.Unlock()
+		if heldFiles[filepath.Clean(f.path)] > 0 {
+			return false
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			return false
+		}
+		return true
+	}
+
+	cutoff := time.Now().Add(-staleAfter)
+	var cached []downloadFileInfo
+	for _, f := range files {
+		switch {
+		case !f.temp:
+			cached = append(cached, f)
+		case f.held:
+		case f.modTime.Before(cutoff) && remove(f):
+			usage.TempFiles--
+			usage.TempBytes -= f.size
+			usage.RemovedTemp++
+		}
+	}
+
+	if usage.Bytes > quota {
+		target := int64(float64(quota) * quotaLowWater)
+		sort.Slice(cached, func(i, j int) bool { return cached[i].modTime.Before(cached[j].modTime) })
+		for i, f := range cached {
+			if usage.Bytes <= target {
+				break
+			}
+			if f.held {
+				continue
+			}
+			if cached[i].removed = remove(f); cached[i].removed {
+				usage.Files--
+				usage.Bytes -= f.size
+				usage.Evicted++
+				usage.EvictedBytes += f.size
+			}
+		}
+		if usage.Bytes > quota {
+			log.Printf("[Janitor] ⚠️ Downloads still take %d bytes, over the quota of %d: the rest is in use", usage.Bytes, quota)
+		}
+	}
+
+	usage.Oldest = time.Time{}
+	for _, f := range cached {
+		if !f.removed && (usage.Oldest.IsZero() || f.modTime.Before(usage.Oldest)) {
+			usage.Oldest = f.modTime
+		}
+	}
+	usage.SweptAt = time.Now()
+
+	lastSweepMu.Lock()
+	lastSweep = usage
+	lastSweepMu.Unlock()
+	return usage, errors.Join(errs...)
+}
+
+// LastSweep returns what the last SweepDownloads found and removed
+func LastSweep() DiskUsage {
+	lastSweepMu.Lock()
+	defer lastSweepMu.Unlock()
+	return lastSweep
+}
+
+// StartJanitor periodically sweeps the downloads directory with the configured quota
+func StartJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			usage, err := SweepDownloads(config.Cfg.DownloadPath, config.Cfg.DownloadsQuota, staleTempAge)
+			if err != nil {
+				log.Printf("[Janitor] ❌ Sweep failed: %v", err)
+			}
+			if usage.Evicted > 0 || usage.RemovedTemp > 0 {
+				log.Printf("[Janitor] Evicted %d cached files (%d bytes), removed %d stale temporary files",
+					usage.Evicted, usage.EvictedBytes, usage.RemovedTemp)
+			}
+		}
+	}()
+}
@@ -0,0 +1,344 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Priority decides which queue a job waits in; high priority jobs always start first
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+)
+
+// cancelGrace is how long Shutdown waits for the jobs it cancelled to return
+const cancelGrace = 5 * time.Second
+
+var (
+	// ErrJobCancelled is returned by Job.Wait for a job cancelled before or while running
+	ErrJobCancelled = errors.New("job cancelled")
+	// ErrShuttingDown is returned by Job.Wait for a job dropped or cancelled by Scheduler.Shutdown
+	ErrShuttingDown = errors.New("shutting down")
+)
+
+// Job is a unit of work run by a Scheduler
+type Job struct {
+	UserID   int64
+	Title    string
+	Priority Priority
+	// Run does the work; it must return soon after ctx is cancelled
+	Run func(ctx context.Context) error
+	// OnQueued, when set, is called with the 1-based queue position whenever it changes
+	OnQueued func(position int)
+
+	id        string
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	position  int
+	queuedAt  time.Time
+	startedAt time.Time
+	done      chan struct{}
+	err       error
+}
+
+// ID identifies the job until it finishes
+func (j *Job) ID() string {
+	return j.id
+}
+
+// Wait blocks until the job has finished and returns the error of Run
+func (j *Job) Wait() error {
+	<-j.done
+	return j.err
+}
+
+// JobInfo is a snapshot of a queued or running job
+type JobInfo struct {
+	ID       string
+	UserID   int64
+	Title    string
+	Running  bool
+	Position int
+	Since    time.Time
+}
+
+// Scheduler runs jobs on a fixed number of workers, high priority first and FIFO within a priority
+type Scheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  [PriorityHigh + 1][]*Job
+	running map[string]*Job
+	active  sync.WaitGroup // running jobs
+	idle    int
+	nextID  uint64
+	closed  bool
+}
+
+// NewScheduler starts a scheduler with the given number of workers (at least one)
+func NewScheduler(workers int) *Scheduler {
+	s := &Scheduler{running: make(map[string]*Job)}
+	s.cond = sync.NewCond(&s.mu)
+	for i := 0; i < max(workers, 1); i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// Submit queues a job and returns its position in the queue, or 0 when a worker picks it up right away.
+// After Shutdown the job is not run and fails with ErrShuttingDown.
+func (s *Scheduler) Submit(job *Job) int {
+	job.ctx, job.cancel = context.WithCancelCause(context.Background())
+	job.done = make(chan struct{})
+	job.queuedAt = time.Now()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		job.finish(ErrShuttingDown)
+		return 0
+	}
+	s.nextID++
+	job.id = strconv.FormatUint(s.nextID, 36)
+	s.queues[job.Priority] = append(s.queues[job.Priority], job)
+	updates := s.positions()
+	position := job.position
+	if position <= s.idle {
+		position = 0
+	}
+	s.mu.Unlock()
+
+	s.cond.Signal()
+	s.notify(updates)
+	return position
+}
+
+// Cancel aborts a queued or running job, reporting whether it was found
+func (s *Scheduler) Cancel(id string) bool {
+	s.mu.Lock()
+	if job, ok := s.running[id]; ok {
+		s.mu.Unlock()
+		job.cancel(ErrJobCancelled)
+		return true
+	}
+
+	for p, queue := range s.queues {
+		for i, job := range queue {
+			if job.id != id {
+				continue
+			}
+
+			s.queues[p] = append(queue[:i:i], queue[i+1:]...)
+			job.position = 0
+			updates := s.positions()
+			s.mu.Unlock()
+
+			job.finish(ErrJobCancelled)
+			s.notify(updates)
+			return true
+		}
+	}
+
+	s.mu.Unlock()
+	return false
+}
+
+// Get returns a snapshot of a queued or running job
+func (s *Scheduler) Get(id string) (JobInfo, bool) {
+	for _, info := range s.Jobs() {
+		if info.ID == id {
+			return info, true
+		}
+	}
+	return JobInfo{}, false
+}
+
+// Jobs returns the running jobs followed by the queued ones in queue order
+func (s *Scheduler) Jobs() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := make([]JobInfo, 0, len(s.running))
+	for _, job := range s.running {
+		infos = append(infos, JobInfo{ID: job.id, UserID: job.UserID, Title: job.Title, Running: true, Since: job.startedAt})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Since.Before(infos[j].Since) })
+
+	for p := PriorityHigh; p >= PriorityNormal; p-- {
+		for _, job := range s.queues[p] {
+			infos = append(infos, JobInfo{ID: job.id, UserID: job.UserID, Title: job.Title, Position: job.position, Since: job.queuedAt})
+		}
+	}
+	return infos
+}
+
+// Depth returns the number of queued and running jobs
+func (s *Scheduler) Depth() (queued, running int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, q := range s.queues {
+		queued += len(q)
+	}
+	return queued, len(s.running)
+}
+
+func (s *Scheduler) worker() {
+	for {
+		s.mu.Lock()
+		job := s.next()
+		for job == nil {
+			s.idle++
+			s.cond.Wait()
+			s.idle--
+			job = s.next()
+		}
+		job.position = 0
+		job.startedAt = time.Now()
+		s.running[job.id] = job
+		s.active.Add(1)
+		updates := s.positions()
+		s.mu.Unlock()
+
+		s.notify(updates)
+		s.run(job)
+	}
+}
+
+// next pops the first job of the highest non-empty queue; s.mu must be held
+func (s *Scheduler) next() *Job {
+	for p := PriorityHigh; p >= PriorityNormal; p-- {
+		if queue := s.queues[p]; len(queue) > 0 {
+			s.queues[p] = queue[1:]
+			return queue[0]
+		}
+	}
+	return nil
+}
+
+// run runs a job on the calling worker; a panic of the job fails it instead of the process
+func (s *Scheduler) run(job *Job) {
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Queue] ❌ Job %s (%s) panicked: %v\n%s", job.id, job.Title, r, debug.Stack())
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+
+		s.mu.Lock()
+		delete(s.running, job.id)
+		s.mu.Unlock()
+
+		job.finish(err)
+		s.active.Done()
+	}()
+
+	err = job.Run(job.ctx)
+	if err != nil && job.ctx.Err() != nil {
+		err = context.Cause(job.ctx)
+	}
+}
+
+// finish releases the context of a job and wakes up its waiters with err
+func (j *Job) finish(err error) {
+	j.cancel(err)
+	j.err = err
+	close(j.done)
+}
+
+// Shutdown stops taking jobs and fails the queued ones with ErrShuttingDown, then lets the running
+// ones finish until ctx is done. Jobs still running then are cancelled with ErrShuttingDown, and an
+// error tells how many there were.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	var dropped []*Job
+	for p := range s.queues {
+		for _, job := range s.queues[p] {
+			job.position = 0
+			dropped = append(dropped, job)
+		}
+		s.queues[p] = nil
+	}
+	s.mu.Unlock()
+
+	for _, job := range dropped {
+		job.finish(ErrShuttingDown)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	cancelled := len(s.running)
+	for _, job := range s.running {
+		job.cancel(ErrShuttingDown)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-drained:
+	case <-time.After(cancelGrace):
+	}
+	return fmt.Errorf("cancelled %d running jobs: %w", cancelled, ctx.Err())
+}
+
+// positionUpdate is a queue position to report to a job
+type positionUpdate struct {
+	job      *Job
+	position int
+}
+
+// positions renumbers the queued jobs and returns the ones whose position changed.
+// Jobs that an idle worker is about to pick up are not reported. s.mu must be held.
+func (s *Scheduler) positions() []positionUpdate {
+	var updates []positionUpdate
+	position := 0
+	for p := PriorityHigh; p >= PriorityNormal; p-- {
+		for _, job := range s.queues[p] {
+			position++
+			if job.position != position {
+				job.position = position
+				if job.OnQueued != nil && position > s.idle {
+					updates = append(updates, positionUpdate{job, position})
+				}
+			}
+		}
+	}
+	return updates
+}
+
+// notify calls the OnQueued hooks off the caller's goroutine, so slow hooks never hold up a worker.
+// Updates that went stale in the meantime (the job moved or started) are dropped.
+func (s *Scheduler) notify(updates []positionUpdate) {
+	if len(updates) == 0 {
+		return
+	}
+	go func() {
+		for _, u := range updates {
+			s.mu.Lock()
+			current := u.job.position == u.position
+			s.mu.Unlock()
+
+			if current {
+				u.job.OnQueued(u.position)
+			}
+		}
+	}()
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runLog records the order jobs start in
+type runLog struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (r *runLog) job(title string, priority Priority) *Job {
+	return &Job{Title: title, Priority: priority, Run: func(ctx context.Context) error {
+		r.mu.Lock()
+		r.order = append(r.order, title)
+		r.mu.Unlock()
+		return nil
+	}}
+}
+
+func (r *runLog) started() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.order...)
+}
+
+// blocker returns a job that runs until release is closed or it is cancelled, and a channel
+// closed once it runs
+func blocker(release <-chan struct{}) (*Job, <-chan struct{}) {
+	running := make(chan struct{})
+	return &Job{Title: "blocker", Run: func(ctx context.Context) error {
+		close(running)
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}, running
+}
+
+func titles(infos []JobInfo) []string {
+	var out []string
+	for _, info := range infos {
+		out = append(out, info.Title)
+	}
+	return out
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	s := NewScheduler(1)
+	release := make(chan struct{})
+	block, running := blocker(release)
+	s.Submit(block)
+	<-running
+
+	var log runLog
+	normal1, normal2 := log.job("normal 1", PriorityNormal), log.job("normal 2", PriorityNormal)
+	high := log.job("high", PriorityHigh)
+	if pos := s.Submit(normal1); pos != 1 {
+		t.Errorf("normal 1 queued at %d, want 1", pos)
+	}
+	if pos := s.Submit(normal2); pos != 2 {
+		t.Errorf("normal 2 queued at %d, want 2", pos)
+	}
+	// A single track goes ahead of every playlist
+	if pos := s.Submit(high); pos != 1 {
+		t.Errorf("high queued at %d, want 1", pos)
+	}
+
+	want := []string{"blocker", "high", "normal 1", "normal 2"}
+	if got := titles(s.Jobs()); !slices.Equal(got, want) {
+		t.Errorf("jobs = %v, want %v", got, want)
+	}
+	if queued, running := s.Depth(); queued != 3 || running != 1 {
+		t.Errorf("depth = %d queued, %d running", queued, running)
+	}
+
+	close(release)
+	for _, job := range []*Job{block, high, normal1, normal2} {
+		if err := job.Wait(); err != nil {
+			t.Errorf("%s: %v", job.Title, err)
+		}
+	}
+	if got, want := log.started(), want[1:]; !slices.Equal(got, want) {
+		t.Errorf("started %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerPositions(t *testing.T) {
+	s := NewScheduler(1)
+	release := make(chan struct{})
+	defer close(release)
+	block, running := blocker(release)
+	s.Submit(block)
+	<-running
+
+	positions := make(chan int, 10)
+	var log runLog
+	normal := log.job("normal", PriorityNormal)
+	normal.OnQueued = func(position int) { positions <- position }
+	s.Submit(normal)
+	if got := <-positions; got != 1 {
+		t.Fatalf("first position %d, want 1", got)
+	}
+
+	// A high priority job moves it back
+	s.Submit(log.job("high", PriorityHigh))
+	select {
+	case got := <-positions:
+		if got != 2 {
+			t.Errorf("position after a high priority job %d, want 2", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("the new position was not reported")
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := NewScheduler(1)
+	release := make(chan struct{})
+	defer close(release)
+	block, running := blocker(release)
+	s.Submit(block)
+	<-running
+
+	var log runLog
+	queued := log.job("queued", PriorityNormal)
+	s.Submit(queued)
+	if !s.Cancel(queued.ID()) {
+		t.Fatal("the queued job was not found")
+	}
+	if err := queued.Wait(); !errors.Is(err, ErrJobCancelled) {
+		t.Errorf("queued job: %v, want ErrJobCancelled", err)
+	}
+
+	if !s.Cancel(block.ID()) {
+		t.Fatal("the running job was not found")
+	}
+	if err := block.Wait(); !errors.Is(err, ErrJobCancelled) {
+		t.Errorf("running job: %v, want ErrJobCancelled", err)
+	}
+
+	if s.Cancel(block.ID()) {
+		t.Error("a finished job was cancelled again")
+	}
+	if jobs := s.Jobs(); len(jobs) != 0 {
+		t.Errorf("jobs left: %v", titles(jobs))
+	}
+	if got := log.started(); len(got) != 0 {
+		t.Errorf("a cancelled job ran: %v", got)
+	}
+}
+
+func TestSchedulerPanic(t *testing.T) {
+	s := NewScheduler(1)
+	job := &Job{Title: "panics", Run: func(ctx context.Context) error { panic("boom") }}
+	s.Submit(job)
+	if err := job.Wait(); err == nil {
+		t.Fatal("a panicking job succeeded")
+	}
+
+	// The worker survived
+	var log runLog
+	next := log.job("next", PriorityNormal)
+	s.Submit(next)
+	if err := next.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchedulerShutdown(t *testing.T) {
+	s := NewScheduler(1)
+	release := make(chan struct{})
+	defer close(release)
+	block, running := blocker(release)
+	s.Submit(block)
+	<-running
+
+	var log runLog
+	queued := log.job("queued", PriorityHigh)
+	s.Submit(queued)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err == nil {
+		t.Error("shutdown reported no running jobs")
+	}
+	for _, job := range []*Job{queued, block} {
+		if err := job.Wait(); !errors.Is(err, ErrShuttingDown) {
+			t.Errorf("%s: %v, want ErrShuttingDown", job.Title, err)
+		}
+	}
+
+	late := log.job("late", PriorityNormal)
+	s.Submit(late)
+	if err := late.Wait(); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("job submitted after shutdown: %v", err)
+	}
+	if got := log.started(); len(got) != 0 {
+		t.Errorf("jobs ran during shutdown: %v", got)
+	}
+}
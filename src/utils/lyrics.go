@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LyricLine is one line of lyrics; Time is only meaningful for synced lyrics
+type LyricLine struct {
+	Time time.Duration
+	Text string
+}
+
+// Lyrics are the words of a track, parsed from plain text or LRC
+type Lyrics struct {
+	Lines  []LyricLine
+	Synced bool // every line has a timestamp
+}
+
+var (
+	// lrcTimestamp matches "[mm:ss]", "[mm:ss.xx]" or "[mm:ss:xx]" at the start of a line
+	lrcTimestamp = regexp.MustCompile(`^\[(\d{1,3}):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	// lrcTag matches LRC header tags such as "[ar:Artist]"
+	lrcTag = regexp.MustCompile(`^\[[a-zA-Z#]+:[^\]]*\]$`)
+)
+
+// ParseLyrics reads plain or LRC lyrics. A line with several timestamps is repeated at each of them.
+func ParseLyrics(raw string) Lyrics {
+	raw = strings.ReplaceAll(strings.TrimSpace(raw), "\r\n", "\n")
+	if raw == "" {
+		return Lyrics{}
+	}
+
+	var timed, plain []LyricLine
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if lrcTag.MatchString(line) {
+			continue
+		}
+
+		var stamps []time.Duration
+		for {
+			m := lrcTimestamp.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			stamps = append(stamps, lrcTime(m[1], m[2], m[3]))
+			line = strings.TrimSpace(line[len(m[0]):])
+		}
+
+		if len(stamps) == 0 {
+			plain = append(plain, LyricLine{Text: line})
+			continue
+		}
+		for _, t := range stamps {
+			timed = append(timed, LyricLine{Time: t, Text: line})
+		}
+	}
+
+	// A few stray plain lines (e.g. credits) don't make timestamped lyrics unsynced
+	if len(timed) > 0 && len(plain) <= len(timed)/10 {
+		sort.SliceStable(timed, func(i, j int) bool { return timed[i].Time < timed[j].Time })
+		return Lyrics{Lines: timed, Synced: true}
+	}
+
+	for _, line := range timed {
+		plain = append(plain, LyricLine{Text: line.Text})
+	}
+	return Lyrics{Lines: trimEmptyLines(plain)}
+}
+
+// lrcTime converts the minute, second and fraction of an LRC timestamp
+func lrcTime(min, sec, frac string) time.Duration {
+	m, _ := strconv.Atoi(min)
+	s, _ := strconv.Atoi(sec)
+	d := time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if frac != "" {
+		f, _ := strconv.Atoi(frac)
+		// "xx" are hundredths, "xxx" milliseconds
+		for i := len(frac); i < 3; i++ {
+			f *= 10
+		}
+		d += time.Duration(f) * time.Millisecond
+	}
+	return d
+}
+
+func trimEmptyLines(lines []LyricLine) []LyricLine {
+	for len(lines) > 0 && lines[0].Text == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1].Text == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Empty reports whether there are no words at all
+func (l Lyrics) Empty() bool {
+	for _, line := range l.Lines {
+		if line.Text != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Plain returns the lyrics without timestamps
+func (l Lyrics) Plain() string {
+	lines := make([]string, len(l.Lines))
+	for i, line := range l.Lines {
+		lines[i] = line.Text
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LRC writes synced lyrics as an LRC file with the track in its header
+func (l Lyrics) LRC(track *TrackInfo) string {
+	var sb strings.Builder
+	for _, tag := range [][2]string{{"ti", track.Name}, {"ar", track.Artist}, {"al", track.Album}} {
+		if tag[1] != "" {
+			sb.WriteString(fmt.Sprintf("[%s:%s]\n", tag[0], tag[1]))
+		}
+	}
+	if track.Duration > 0 {
+		sb.WriteString(fmt.Sprintf("[length:%02d:%02d]\n", track.Duration/60, track.Duration%60))
+	}
+
+	for _, line := range l.Lines {
+		cs := line.Time.Milliseconds() / 10
+		sb.WriteString(fmt.Sprintf("[%02d:%02d.%02d]%s\n", cs/6000, cs/100%60, cs%100, line.Text))
+	}
+	return sb.String()
+}
+
+// Tag is the value of the lyrics tag of an audio file: LRC text when synced, so players that
+// understand it can follow along, plain text otherwise
+func (l Lyrics) Tag(track *TrackInfo) string {
+	if l.Synced {
+		return l.LRC(track)
+	}
+	return l.Plain()
+}
+
+// GetLyrics returns a delivered track whose lyrics are known, as a track carrying them
+func GetLyrics(tc string) (*TrackInfo, bool) {
+	stored, ok := GetStoredTrack(tc)
+	if !ok || ParseLyrics(stored.Lyrics).Empty() {
+		return nil, false
+	}
+	return stored.Track(tc), true
+}
+
+// SplitText splits text at line breaks into chunks of at most limit characters; a longer line is cut
+func SplitText(text string, limit int) []string {
+	var chunks []string
+	var current strings.Builder
+	size := 0
+
+	flush := func() {
+		if size > 0 {
+			chunks = append(chunks, strings.TrimRight(current.String(), "\n"))
+			current.Reset()
+			size = 0
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(line)
+		for len(runes) > limit {
+			flush()
+			chunks = append(chunks, string(runes[:limit]))
+			runes = runes[limit:]
+		}
+		if size+len(runes)+1 > limit {
+			flush()
+		}
+		current.WriteString(string(runes) + "\n")
+		size += len(runes) + 1
+	}
+	flush()
+	return chunks
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLyricsSynced(t *testing.T) {
+	l := ParseLyrics("[ti:Song]\r\n[ar:Artist]\r\n[00:01.50]First\r\n[00:10.00][01:00.00] Chorus\r\n[00:05.123]Second\r\n")
+	if !l.Synced {
+		t.Fatal("timestamped lyrics are not synced")
+	}
+	// Header tags are dropped, a line with two timestamps is repeated and lines are in time order
+	want := []LyricLine{
+		{1500 * time.Millisecond, "First"},
+		{5123 * time.Millisecond, "Second"},
+		{10 * time.Second, "Chorus"},
+		{time.Minute, "Chorus"},
+	}
+	if !slices.Equal(l.Lines, want) {
+		t.Errorf("lines = %v, want %v", l.Lines, want)
+	}
+}
+
+func TestParseLyricsTimestamps(t *testing.T) {
+	tests := []struct {
+		line string
+		want time.Duration
+	}{
+		{"[01:02]x", 62 * time.Second},
+		{"[1:02]x", 62 * time.Second},
+		{"[100:00]x", 100 * time.Minute},
+		{"[00:01.5]x", 1500 * time.Millisecond},
+		{"[00:01.05]x", 1050 * time.Millisecond},
+		{"[00:01.005]x", 1005 * time.Millisecond},
+		{"[00:01:50]x", 1500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			l := ParseLyrics(tt.line)
+			if !l.Synced || len(l.Lines) != 1 || l.Lines[0] != (LyricLine{tt.want, "x"}) {
+				t.Errorf("ParseLyrics(%q) = %+v, want x at %s", tt.line, l, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLyricsStrayLines(t *testing.T) {
+	// synced builds lyrics of n timestamped lines and one plain line
+	synced := func(n int) string {
+		var sb strings.Builder
+		sb.WriteString("Lyrics by someone\n")
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&sb, "[00:%02d.00]line\n", i)
+		}
+		return sb.String()
+	}
+
+	// One plain line in ten is credits and is dropped
+	l := ParseLyrics(synced(10))
+	if !l.Synced || len(l.Lines) != 10 || l.Lines[0].Text != "line" {
+		t.Errorf("10 timed lines and one plain: synced %v, %d lines", l.Synced, len(l.Lines))
+	}
+	// More than that and the lyrics are plain text, keeping every line
+	l = ParseLyrics(synced(9))
+	if l.Synced || len(l.Lines) != 10 || l.Lines[0].Text != "Lyrics by someone" {
+		t.Errorf("9 timed lines and one plain: synced %v, %d lines", l.Synced, len(l.Lines))
+	}
+	for _, line := range l.Lines {
+		if line.Time != 0 {
+			t.Errorf("unsynced line %q has a time", line.Text)
+		}
+	}
+}
+
+func TestParseLyricsPlain(t *testing.T) {
+	l := ParseLyrics("\n\n  Hello \r\n\nWorld\n\n")
+	if l.Synced || l.Empty() {
+		t.Fatalf("synced %v, empty %v", l.Synced, l.Empty())
+	}
+	if got := l.Plain(); got != "Hello\n\nWorld" {
+		t.Errorf("Plain() = %q", got)
+	}
+	if got := l.Tag(&TrackInfo{Name: "Song"}); got != l.Plain() {
+		t.Errorf("Tag() = %q, want the plain text", got)
+	}
+
+	for _, raw := range []string{"", " \n\n ", "[ar:Artist]\n[ti:Song]\n"} {
+		if l := ParseLyrics(raw); !l.Empty() {
+			t.Errorf("ParseLyrics(%q) is not empty: %+v", raw, l)
+		}
+	}
+}
+
+func TestLyricsLRC(t *testing.T) {
+	l := Lyrics{Synced: true, Lines: []LyricLine{
+		{1500 * time.Millisecond, "First"},
+		{61*time.Second + 237*time.Millisecond, "Second"},
+	}}
+	track := &TrackInfo{Name: "Song", Artist: "Artist", Duration: 185}
+	want := "[ti:Song]\n[ar:Artist]\n[length:03:05]\n[00:01.50]First\n[01:01.23]Second\n"
+	if got := l.LRC(track); got != want {
+		t.Errorf("LRC() = %q, want %q", got, want)
+	}
+	if got := l.Tag(track); got != want {
+		t.Errorf("Tag() = %q, want the LRC text", got)
+	}
+
+	// It reads back to the same lines, to the hundredth
+	back := ParseLyrics(want)
+	if !back.Synced || len(back.Lines) != 2 || back.Lines[1] != (LyricLine{61*time.Second + 230*time.Millisecond, "Second"}) {
+		t.Errorf("read back as %+v", back)
+	}
+
+	// Missing fields leave their tags out
+	if got := l.LRC(&TrackInfo{Name: "Song", Album: "Album"}); !strings.HasPrefix(got, "[ti:Song]\n[al:Album]\n[00:01.50]") {
+		t.Errorf("LRC() = %q", got)
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  []string
+	}{
+		{"aaa\nbbb\ncc", 8, []string{"aaa\nbbb", "cc"}},
+		{"aaa\nbbb", 100, []string{"aaa\nbbb"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"ééééé", 2, []string{"éé", "éé", "é"}},
+	}
+	for _, tt := range tests {
+		got := SplitText(tt.text, tt.limit)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("SplitText(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+		}
+		for _, chunk := range got {
+			if n := len([]rune(chunk)); n > tt.limit {
+				t.Errorf("SplitText(%q, %d): chunk %q has %d characters", tt.text, tt.limit, chunk, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"songBot/src/ogg"
+	"time"
+)
+
+// sniffSize is how much of a file is read to recognize its container
+const sniffSize = 64 << 10
+
+// AudioInfo is what the content of an audio file tells about it
+type AudioInfo struct {
+	Container string // "ogg", "mp3", "m4a", "flac" or "webm"; empty when unknown
+	MimeType  string
+	Ext       string
+	Duration  time.Duration // zero when it could not be determined
+}
+
+// Known reports whether the container was recognized
+func (a AudioInfo) Known() bool {
+	return a.Container != ""
+}
+
+var (
+	errUnknownContainer = errors.New("unknown audio container")
+
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG-1
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG-2 and 2.5
+	}
+	mp3SampleRates = map[byte][3]int{
+		3: {44100, 48000, 32000}, // MPEG-1
+		2: {22050, 24000, 16000}, // MPEG-2
+		0: {11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// ProbeAudio recognizes the container of an audio file from its content and reads its duration.
+// An unknown container returns an empty AudioInfo and an error.
+func ProbeAudio(path string) (AudioInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	stat, err := f.Stat()
+	if err != nil {
+		return AudioInfo{}, err
+	}
+
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return AudioInfo{}, err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("OggS")):
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return AudioInfo{}, err
+		}
+		info := AudioInfo{Container: "ogg", MimeType: "audio/ogg", Ext: ".ogg"}
+		stream, err := ogg.Probe(f)
+		if stream.Codec == ogg.CodecOpus {
+			info.Ext = ".opus"
+		}
+		info.Duration = stream.Duration
+		return info, err
+
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return AudioInfo{Container: "flac", MimeType: "audio/flac", Ext: ".flac", Duration: flacDuration(head)}, nil
+
+	case len(head) >= 8 && bytes.Equal(head[4:8], []byte("ftyp")):
+		return AudioInfo{Container: "m4a", MimeType: "audio/mp4", Ext: ".m4a", Duration: mp4Duration(f, stat.Size())}, nil
+
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return AudioInfo{Container: "webm", MimeType: "audio/webm", Ext: ".webm", Duration: webmDuration(head)}, nil
+	}
+
+	if duration, ok := mp3Duration(f, head, stat.Size()); ok {
+		return AudioInfo{Container: "mp3", MimeType: "audio/mpeg", Ext: ".mp3", Duration: duration}, nil
+	}
+	return AudioInfo{}, errUnknownContainer
+}
+
+// flacDuration reads the total samples and sample rate of the STREAMINFO block, which always comes first
+func flacDuration(head []byte) time.Duration {
+	// "fLaC", block header (4 bytes), then 10 bytes of block and frame sizes
+	if len(head) < 4+4+18 || head[4]&0x7F != 0 {
+		return 0
+	}
+	packed := binary.BigEndian.Uint64(head[18:26])
+	rate := packed >> 44
+	samples := packed & (1<<36 - 1)
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(samples) * time.Second / time.Duration(rate)
+}
+
+// mp3Duration finds the first MPEG layer III frame after any ID3v2 tag. The duration comes from the
+// Xing/Info frame count of VBR files, or from the bitrate for CBR files. A tag with a large cover
+// can outgrow head, so the frames are read from r where the tag ends.
+func mp3Duration(r io.ReaderAt, head []byte, size int64) (time.Duration, bool) {
+	var start int64
+	if bytes.HasPrefix(head, []byte("ID3")) && len(head) >= 10 {
+		// Syncsafe size, plus the 10 byte header and a footer when flagged
+		start = 10 + int64(int(head[6])<<21|int(head[7])<<14|int(head[8])<<7|int(head[9]))
+		if head[5]&0x10 != 0 {
+			start += 10
+		}
+
+		head = make([]byte, sniffSize)
+		n, err := r.ReadAt(head, start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, false
+		}
+		head = head[:n]
+	}
+
+	for i := 0; i+4 <= len(head); i++ {
+		if head[i] != 0xFF || head[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+
+		version := (head[i+1] >> 3) & 0x03
+		layer := (head[i+1] >> 1) & 0x03
+		bitrateIndex := head[i+2] >> 4
+		rateIndex := (head[i+2] >> 2) & 0x03
+		if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		table, samplesPerFrame := 1, 576
+		if version == 3 {
+			table, samplesPerFrame = 0, 1152
+		}
+		bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+		sampleRate := mp3SampleRates[version][rateIndex]
+
+		// Stray sync bits are common in other data, a real frame is followed by another one
+		padding := int(head[i+2]>>1) & 1
+		if next := i + samplesPerFrame/8*bitrate/sampleRate + padding; next+2 <= len(head) &&
+			(head[next] != 0xFF || head[next+1]&0xE0 != 0xE0) {
+			continue
+		}
+
+		// The Xing/Info header sits after the side information of the first frame
+		sideInfo := 32
+		mono := head[i+3]>>6 == 3
+		switch {
+		case version == 3 && mono, version != 3 && !mono:
+			sideInfo = 17
+		case version != 3 && mono:
+			sideInfo = 9
+		}
+		if x := i + 4 + sideInfo; x+12 <= len(head) {
+			tag := string(head[x : x+4])
+			if (tag == "Xing" || tag == "Info") && binary.BigEndian.Uint32(head[x+4:])&1 != 0 {
+				frames := binary.BigEndian.Uint32(head[x+8:])
+				return time.Duration(frames) * time.Duration(samplesPerFrame) * time.Second / time.Duration(sampleRate), true
+			}
+		}
+
+		seconds := float64(size-start-int64(i)) * 8 / float64(bitrate)
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// mp4Duration reads the duration of the movie header (moov/mvhd), which may sit at the end of the file
+func mp4Duration(r io.ReaderAt, size int64) time.Duration {
+	moov, moovSize, ok := findBox(r, 0, size, "moov")
+	if !ok {
+		return 0
+	}
+	mvhd, _, ok := findBox(r, moov, moov+moovSize, "mvhd")
+	if !ok {
+		return 0
+	}
+
+	var buf [32]byte
+	if _, err := r.ReadAt(buf[:], mvhd); err != nil {
+		return 0
+	}
+
+	var timescale, duration uint64
+	if buf[0] == 1 {
+		// version, flags, 64-bit creation and modification times
+		timescale = uint64(binary.BigEndian.Uint32(buf[20:]))
+		duration = binary.BigEndian.Uint64(buf[24:])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(buf[12:]))
+		duration = uint64(binary.BigEndian.Uint32(buf[16:]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(duration) * time.Second / time.Duration(timescale)
+}
+
+// findBox looks for a box among the boxes between start and end and returns the offset and size of its payload
+func findBox(r io.ReaderAt, start, end int64, name string) (int64, int64, bool) {
+	var header [16]byte
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, false
+		}
+
+		size, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, 0, false
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if size < headerSize {
+			return 0, 0, false
+		}
+
+		if string(header[4:8]) == name {
+			return offset + headerSize, size - headerSize, true
+		}
+		offset += size
+	}
+	return 0, 0, false
+}
+
+// webmDuration reads Segment/Info/Duration, scaled by the TimecodeScale, from the start of a WebM file
+func webmDuration(head []byte) time.Duration {
+	const (
+		idSegment       = 0x18538067
+		idInfo          = 0x1549A966
+		idTimecodeScale = 0x2AD7B1
+		idDuration      = 0x4489
+	)
+
+	pos := 0
+	for pos < len(head) {
+		id, size, dataStart, ok := ebmlElement(head, pos)
+		if !ok {
+			return 0
+		}
+
+		switch id {
+		case idSegment, idInfo:
+			// Descend into the containers on the way to Duration
+			pos = dataStart
+			if id != idInfo {
+				continue
+			}
+
+			scale := uint64(1000000)
+			var duration float64
+			end := min(dataStart+int(size), len(head))
+			for p := dataStart; p < end; {
+				cid, csize, cstart, ok := ebmlElement(head, p)
+				if !ok || cstart+int(csize) > len(head) {
+					break
+				}
+				data := head[cstart : cstart+int(csize)]
+				switch {
+				case cid == idTimecodeScale:
+					scale = 0
+					for _, b := range data {
+						scale = scale<<8 | uint64(b)
+					}
+				case cid == idDuration && csize == 4:
+					duration = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+				case cid == idDuration && csize == 8:
+					duration = math.Float64frombits(binary.BigEndian.Uint64(data))
+				}
+				p = cstart + int(csize)
+			}
+			return time.Duration(duration * float64(scale))
+		default:
+			pos = dataStart + int(size)
+		}
+	}
+	return 0
+}
+
+// ebmlElement decodes the ID and size of the EBML element at pos. An unknown size is returned as the rest of buf.
+func ebmlElement(buf []byte, pos int) (id uint64, size uint64, dataStart int, ok bool) {
+	id, idLen, ok := ebmlVint(buf, pos, true)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	size, sizeLen, ok := ebmlVint(buf, pos+idLen, false)
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	dataStart = pos + idLen + sizeLen
+	if size == 1<<(7*uint(sizeLen))-1 {
+		size = uint64(len(buf) - dataStart)
+	}
+	return id, size, dataStart, true
+}
+
+// ebmlVint decodes a variable length integer; IDs keep their length marker, sizes drop it
+func ebmlVint(buf []byte, pos int, keepMarker bool) (uint64, int, bool) {
+	if pos >= len(buf) || buf[pos] == 0 {
+		return 0, 0, false
+	}
+
+	length := 1
+	for mask := byte(0x80); buf[pos]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if pos+length > len(buf) {
+		return 0, 0, false
+	}
+
+	value := uint64(buf[pos])
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	for _, b := range buf[pos+1 : pos+length] {
+		value = value<<8 | uint64(b)
+	}
+	return value, length, true
+}
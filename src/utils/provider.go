@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"songBot/src/config"
+	"strconv"
+	"sync"
+)
+
+// MusicProvider is a backend that finds tracks and resolves them to downloadable audio
+type MusicProvider interface {
+	// Search finds up to limit tracks matching a free-text query
+	Search(query string, limit int) (*PlatformTracks, error)
+	// GetInfo lists the tracks behind a track, album or playlist URL
+	GetInfo(rawURL string) (*PlatformTracks, error)
+	// GetTrack returns the downloadable details of a track given its URL or ID
+	GetTrack(id string) (*TrackInfo, error)
+	// Resolve lists the tracks of a URL the provider understands, or searches for anything else.
+	// isURL tells which of the two happened.
+	Resolve(query string, limit int) (tracks *PlatformTracks, isURL bool, err error)
+	// Ping checks that the provider can serve requests right now
+	Ping(ctx context.Context) error
+}
+
+var (
+	provider   MusicProvider = APIProvider{}
+	providerMu sync.RWMutex
+)
+
+// NewProvider creates the provider named in the configuration ("api" or "fake")
+func NewProvider(name string) (MusicProvider, error) {
+	switch name {
+	case "", "api":
+		return APIProvider{}, nil
+	case "fake":
+		return NewFakeProvider(config.Cfg.FakeMusicDir)
+	default:
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+}
+
+// SetProvider replaces the provider used by every handler
+func SetProvider(p MusicProvider) {
+	providerMu.Lock()
+	provider = p
+	providerMu.Unlock()
+}
+
+// Provider returns the provider used by every handler
+func Provider() MusicProvider {
+	providerMu.RLock()
+	defer providerMu.RUnlock()
+	return provider
+}
+
+// APIProvider serves tracks from the remote API at config.Cfg.ApiUrl
+type APIProvider struct{}
+
+func (APIProvider) Search(query string, limit int) (*PlatformTracks, error) {
+	return NewApiData(query).Search(strconv.Itoa(limit))
+}
+
+func (APIProvider) GetInfo(rawURL string) (*PlatformTracks, error) {
+	return NewApiData(rawURL).GetInfo()
+}
+
+func (APIProvider) GetTrack(id string) (*TrackInfo, error) {
+	return NewApiData(id).GetTrack()
+}
+
+func (APIProvider) Resolve(query string, limit int) (*PlatformTracks, bool, error) {
+	api := NewApiData(query)
+	if api.IsValid(query) {
+		tracks, err := api.GetInfo()
+		return tracks, true, err
+	}
+	tracks, err := api.Search(strconv.Itoa(limit))
+	return tracks, false, err
+}
+
+func (APIProvider) Ping(ctx context.Context) error {
+	return NewApiData("").Ping(ctx)
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// pruneEvery is how many Allow calls pass between two sweeps of idle buckets
+const pruneEvery = 1024
+
+// RateLimiter is a token bucket limiter keyed by arbitrary strings (e.g. user or chat IDs)
+type RateLimiter struct {
+	mu      sync.Mutex
+	burst   float64
+	rate    float64 // tokens per second
+	buckets map[string]*rateBucket
+	calls   int
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows bursts of count events, refilled evenly over per.
+// A non-positive count disables the limiter.
+func NewRateLimiter(count int, per time.Duration) *RateLimiter {
+	l := &RateLimiter{burst: float64(count), buckets: make(map[string]*rateBucket)}
+	if count > 0 && per > 0 {
+		l.rate = float64(count) / per.Seconds()
+	}
+	return l
+}
+
+// Allow takes one token from the bucket of every key. If any bucket is empty nothing is taken
+// and the time until a token is available in all of them is returned.
+func (l *RateLimiter) Allow(keys ...string) (bool, time.Duration) {
+	if l == nil || l.rate == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if wait := l.wait(now, keys); wait > 0 {
+		return false, wait
+	}
+	for _, key := range keys {
+		l.buckets[key].tokens--
+	}
+	return true, 0
+}
+
+// Wait returns how long until every key has a token, without taking any
+func (l *RateLimiter) Wait(keys ...string) time.Duration {
+	if l == nil || l.rate == 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.wait(time.Now(), keys)
+}
+
+// wait refills the buckets of keys and returns the longest time until one of them has a token
+func (l *RateLimiter) wait(now time.Time, keys []string) time.Duration {
+	l.calls++
+	if l.calls%pruneEvery == 0 {
+		l.prune(now)
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &rateBucket{tokens: l.burst, last: now}
+			l.buckets[key] = b
+		}
+
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+		if b.tokens < 1 {
+			if w := time.Duration((1 - b.tokens) / l.rate * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+	}
+	return wait
+}
+
+// prune drops buckets that have refilled completely, they behave like new ones
+func (l *RateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
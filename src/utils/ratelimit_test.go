@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	l := NewRateLimiter(3, time.Minute)
+	for i := 0; i < 3; i++ {
+		if ok, wait := l.Allow("a"); !ok {
+			t.Fatalf("event %d denied, wait %s", i+1, wait)
+		}
+	}
+
+	// One token comes back every 20s
+	ok, wait := l.Allow("a")
+	if ok || wait <= 19*time.Second || wait > 20*time.Second {
+		t.Errorf("4th event: ok %v, wait %s, want denied for about 20s", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("another key shares the spent budget")
+	}
+}
+
+func TestRateLimiterAllOrNothing(t *testing.T) {
+	l := NewRateLimiter(2, time.Minute)
+	l.Allow("chat")
+	l.Allow("chat")
+
+	if ok, _ := l.Allow("user", "chat"); ok {
+		t.Fatal("allowed with an empty bucket")
+	}
+	// The denied call took nothing from the user bucket
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("user"); !ok {
+			t.Fatalf("user event %d denied", i+1)
+		}
+	}
+	if wait := l.Wait("user"); wait <= 0 {
+		t.Errorf("wait = %s once the user bucket is spent", wait)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := NewRateLimiter(2, time.Minute)
+	l.Allow("a")
+	l.Allow("a")
+
+	now := time.Now()
+	if wait := l.wait(now.Add(10*time.Second), []string{"a"}); wait <= 19*time.Second || wait > 20*time.Second {
+		t.Errorf("after 10s: wait %s, want about 20s", wait)
+	}
+	if wait := l.wait(now.Add(30*time.Second), []string{"a"}); wait != 0 {
+		t.Errorf("after 30s: wait %s, want a token", wait)
+	}
+	// The bucket never holds more than the burst
+	l.wait(now.Add(time.Hour), []string{"a"})
+	if tokens := l.buckets["a"].tokens; tokens != 2 {
+		t.Errorf("after an hour: %v tokens, want 2", tokens)
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	var missing *RateLimiter
+	for _, l := range []*RateLimiter{NewRateLimiter(0, time.Minute), missing} {
+		for i := 0; i < 100; i++ {
+			if ok, _ := l.Allow("a"); !ok {
+				t.Fatal("a disabled limiter denied an event")
+			}
+		}
+	}
+}
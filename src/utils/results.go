@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errResultsNotFound = errors.New("results not found or expired")
+
+// ResultSet is a list of tracks shown to a user page by page; it is kept in the
+// database so the buttons keep working after a restart
+type ResultSet struct {
+	Query   string       `json:"query"`
+	Owner   int64        `json:"owner"`  // only this user may use the buttons, 0 for anyone
+	Format  string       `json:"format"` // one-off format override, empty for the user preference
+	Tracks  []MusicTrack `json:"tracks"`
+	Expires int64        `json:"expires"`
+}
+
+// Page returns the tracks of the given page (0-based) and the number of pages; an out of range page is clamped
+func (r *ResultSet) Page(page, perPage int) ([]MusicTrack, int, int) {
+	pages := max(1, (len(r.Tracks)+perPage-1)/perPage)
+	page = min(max(page, 0), pages-1)
+	start := page * perPage
+	end := min(start+perPage, len(r.Tracks))
+	return r.Tracks[start:end], page, pages
+}
+
+// SaveResults stores a result set for the token lifetime and returns its ID
+func SaveResults(set ResultSet) (string, error) {
+	now := time.Now()
+	set.Expires = now.Add(tokenLifetime()).Unix()
+	id := generateShortToken(fmt.Sprintf("%d:%d:%s", now.UnixNano(), set.Owner, set.Query))
+	if err := dbPut(bucketResults, id, set); err != nil {
+		return "", fmt.Errorf("failed to save results: %w", err)
+	}
+	return id, nil
+}
+
+// GetResults loads a result set saved with SaveResults
+func GetResults(id string) (*ResultSet, error) {
+	var set ResultSet
+	ok, err := dbGet(bucketResults, id, &set)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || time.Now().Unix() >= set.Expires {
+		return nil, errResultsNotFound
+	}
+	return &set, nil
+}
+
+// sweepResults removes the expired result sets
+func sweepResults(now time.Time) (int, error) {
+	return dbDeleteWhere(bucketResults, func(_, v []byte) bool {
+		var set ResultSet
+		if err := json.Unmarshal(v, &set); err != nil {
+			return true
+		}
+		return now.Unix() >= set.Expires
+	})
+}
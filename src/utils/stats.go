@@ -0,0 +1,339 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"songBot/src/metrics"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request kinds counted by the stats
+const (
+	StatSearch   = "search"
+	StatTrack    = "track"
+	StatInline   = "inline"
+	StatPlaylist = "playlist"
+)
+
+// Timed phases of a delivery
+const (
+	PhaseDownload  = "download"
+	PhaseDecrypt   = "decrypt" // Spotify audio is downloaded and decrypted as one stream
+	PhaseTranscode = "transcode"
+	PhaseUpload    = "upload"
+)
+
+// statsDays is how many days of active user counts are kept
+const statsDays = 30
+
+// TimingBounds are the upper bounds of the timing histogram buckets; the last bucket is unbounded
+var TimingBounds = []time.Duration{
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second,
+	30 * time.Second, time.Minute, 2 * time.Minute,
+}
+
+// Timing is a histogram of durations
+type Timing struct {
+	Count   int64   `json:"count"`
+	TotalMs int64   `json:"total_ms"`
+	Buckets []int64 `json:"buckets"` // one per TimingBounds, plus the unbounded one
+}
+
+// Average is the mean duration, 0 without samples
+func (t *Timing) Average() time.Duration {
+	if t.Count == 0 {
+		return 0
+	}
+	return time.Duration(t.TotalMs/t.Count) * time.Millisecond
+}
+
+// Quantile is the upper bound of the bucket holding the q-th quantile (0 < q <= 1); -1 when it
+// falls in the unbounded bucket
+func (t *Timing) Quantile(q float64) time.Duration {
+	rank := int64(q*float64(t.Count) + 0.5)
+	var seen int64
+	for i, n := range t.Buckets {
+		seen += n
+		if seen >= rank && i < len(TimingBounds) {
+			return TimingBounds[i]
+		}
+	}
+	return -1
+}
+
+func (t *Timing) observe(d time.Duration) {
+	if len(t.Buckets) != len(TimingBounds)+1 {
+		t.Buckets = make([]int64, len(TimingBounds)+1)
+	}
+	i := sort.Search(len(TimingBounds), func(i int) bool { return d <= TimingBounds[i] })
+	t.Buckets[i]++
+	t.Count++
+	t.TotalMs += d.Milliseconds()
+}
+
+// Stats are the totals the bot collected since Since; they are kept in the database
+type Stats struct {
+	Since       int64                       `json:"since"`
+	Requests    map[string]int64            `json:"requests"`  // by kind
+	Platforms   map[string]int64            `json:"platforms"` // by platform of the track or URL
+	Outcomes    map[string]map[string]int64 `json:"outcomes"`  // by kind, then "ok" or error class
+	CacheHits   int64                       `json:"cache_hits"`
+	CacheMisses int64                       `json:"cache_misses"`
+	Timings     map[string]*Timing          `json:"timings"`     // by phase
+	DailyUsers  map[string]int              `json:"daily_users"` // active users by day (2006-01-02)
+
+	// Day and DayUsers count today's active users; users are kept as hashes keyed with daySecret,
+	// only until the day ends
+	Day      string          `json:"day"`
+	DayUsers map[string]bool `json:"day_users"`
+}
+
+func newStats() *Stats {
+	return &Stats{
+		Since:      time.Now().Unix(),
+		Requests:   map[string]int64{},
+		Platforms:  map[string]int64{},
+		Outcomes:   map[string]map[string]int64{},
+		Timings:    map[string]*Timing{},
+		DailyUsers: map[string]int{},
+		DayUsers:   map[string]bool{},
+	}
+}
+
+// ActiveUsers returns the active users of a day
+func (s *Stats) ActiveUsers(day time.Time) int {
+	key := day.UTC().Format(time.DateOnly)
+	if key == s.Day {
+		return len(s.DayUsers)
+	}
+	return s.DailyUsers[key]
+}
+
+// CacheHitRate is the share of track requests served from the file cache, 0 without requests
+func (s *Stats) CacheHitRate() float64 {
+	if total := s.CacheHits + s.CacheMisses; total > 0 {
+		return float64(s.CacheHits) / float64(total)
+	}
+	return 0
+}
+
+// rollDay moves the users of a finished day into DailyUsers and drops the days beyond statsDays
+func (s *Stats) rollDay(now time.Time) {
+	today := now.UTC().Format(time.DateOnly)
+	if s.Day == today {
+		return
+	}
+	if s.Day != "" {
+		s.DailyUsers[s.Day] = len(s.DayUsers)
+	}
+	s.Day, s.DayUsers = today, map[string]bool{}
+
+	oldest := now.UTC().AddDate(0, 0, -statsDays).Format(time.DateOnly)
+	for day := range s.DailyUsers {
+		if day < oldest {
+			delete(s.DailyUsers, day)
+		}
+	}
+}
+
+var (
+	stats   = newStats()
+	statsMu sync.Mutex
+
+	statsKey = "totals"
+
+	// daySecret keys the DayUsers hashes of daySecretDay. It is random, changes with the day and is
+	// never saved, so the hashes cannot be matched against user ids; a restart counts users again.
+	daySecret    []byte
+	daySecretDay string
+)
+
+// LoadStats restores the totals saved by FlushStats; they decode over empty maps, so none is nil
+func LoadStats() error {
+	loaded := newStats()
+	ok, err := dbGet(bucketStats, statsKey, loaded)
+	if err != nil || !ok {
+		return err
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats = loaded
+	return nil
+}
+
+// FlushStats saves the totals to the database
+func FlushStats() error {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	return dbPut(bucketStats, statsKey, stats)
+}
+
+// StartStatsFlusher periodically saves the totals
+func StartStatsFlusher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := FlushStats(); err != nil && !errors.Is(err, errDBNotOpened) {
+				log.Printf("[Stats] ❌ Flush failed: %v", err)
+			}
+		}
+	}()
+}
+
+// GetStats returns a copy of the current totals
+func GetStats() Stats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	s := *stats
+	s.Requests = copyMap(stats.Requests)
+	s.Platforms = copyMap(stats.Platforms)
+	s.Outcomes = make(map[string]map[string]int64, len(stats.Outcomes))
+	for kind, outcomes := range stats.Outcomes {
+		s.Outcomes[kind] = copyMap(outcomes)
+	}
+	s.Timings = make(map[string]*Timing, len(stats.Timings))
+	for phase, t := range stats.Timings {
+		c := *t
+		c.Buckets = append([]int64(nil), t.Buckets...)
+		s.Timings[phase] = &c
+	}
+	s.DailyUsers = copyMap(stats.DailyUsers)
+	s.DayUsers = copyMap(stats.DayUsers)
+	return s
+}
+
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// RecordRequest counts a request of the given kind and marks the user active today
+func RecordRequest(kind string, userID int64) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats.Requests[kind]++
+	stats.rollDay(time.Now())
+	if userID == 0 {
+		return
+	}
+
+	if daySecretDay != stats.Day {
+		daySecret = make([]byte, 32)
+		if _, err := rand.Read(daySecret); err != nil {
+			log.Printf("[Stats] ❌ Failed to create the daily secret: %v", err)
+			return
+		}
+		daySecretDay = stats.Day
+	}
+	mac := hmac.New(sha256.New, daySecret)
+	mac.Write([]byte(strconv.FormatInt(userID, 10)))
+	stats.DayUsers[hex.EncodeToString(mac.Sum(nil)[:8])] = true
+}
+
+// RecordPlatform counts a request for a track or URL of the given platform
+func RecordPlatform(platform string) {
+	if platform = strings.ToLower(strings.TrimSpace(platform)); platform == "" {
+		platform = "unknown"
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats.Platforms[platform]++
+}
+
+// RecordOutcome counts how a request of the given kind ended: "ok" or an error class, see ErrorClass
+func RecordOutcome(kind, outcome string) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if stats.Outcomes[kind] == nil {
+		stats.Outcomes[kind] = map[string]int64{}
+	}
+	stats.Outcomes[kind][outcome]++
+}
+
+// RecordCache counts a track request served from (hit) or missing in the file cache
+func RecordCache(hit bool) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if hit {
+		stats.CacheHits++
+	} else {
+		stats.CacheMisses++
+	}
+}
+
+// ObserveDuration adds the duration of a phase to its histogram, and to the metrics
+func ObserveDuration(phase string, d time.Duration) {
+	metrics.PhaseDuration.Observe(d.Seconds(), phase)
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	t := stats.Timings[phase]
+	if t == nil {
+		t = &Timing{}
+		stats.Timings[phase] = t
+	}
+	t.observe(d)
+}
+
+// ErrorClass groups an error for the stats: "ok" for nil, else timeout, shutdown, cancelled, network,
+// not_found, unavailable, flood, stale_file, telegram or other
+func ErrorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrShuttingDown):
+		return "shutdown"
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrJobCancelled):
+		return "cancelled"
+	case errors.Is(err, errTrackNotFound), errors.Is(err, errResultsNotFound):
+		return "not_found"
+	case errors.Is(err, errMissingCDNURL), errors.Is(err, errMissingKey):
+		return "unavailable"
+	case IsStaleFileError(err):
+		return "stale_file"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "FLOOD"):
+		return "flood"
+	case strings.Contains(msg, "status code"), strings.Contains(msg, "HTTP request failed"):
+		return "network"
+	case isTelegramError(msg):
+		return "telegram"
+	}
+	return "other"
+}
+
+// isTelegramError reports whether msg looks like an RPC error of Telegram, e.g. "[MEDIA_EMPTY] ..."
+func isTelegramError(msg string) bool {
+	for _, word := range strings.FieldsFunc(msg, func(r rune) bool { return r == ' ' || r == '[' || r == ']' || r == ':' }) {
+		if len(word) > 3 && strings.Contains(word, "_") && strings.ToUpper(word) == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimingQuantile(t *testing.T) {
+	var timing Timing
+	for _, d := range []time.Duration{500 * time.Millisecond, time.Second, 4 * time.Second, 10 * time.Minute} {
+		timing.observe(d)
+	}
+	// A duration on a bound counts in that bound's bucket
+	if timing.Buckets[0] != 2 {
+		t.Errorf("buckets = %v, want two samples within 1s", timing.Buckets)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.25, time.Second},
+		{0.5, time.Second},
+		{0.75, 5 * time.Second},
+		{0.95, -1}, // the 10m sample is beyond every bound
+		{1, -1},
+	}
+	for _, tt := range tests {
+		if got := timing.Quantile(tt.q); got != tt.want {
+			t.Errorf("Quantile(%v) = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+
+	if got, want := timing.Average(), (500+1000+4000+600000)/4*time.Millisecond; got != want {
+		t.Errorf("Average() = %s, want %s", got, want)
+	}
+	if got := (&Timing{}).Average(); got != 0 {
+		t.Errorf("Average() without samples = %s, want 0", got)
+	}
+}
+
+func TestStatsRollDay(t *testing.T) {
+	s := newStats()
+	s.DailyUsers["2026-02-08"] = 5 // 31 days before the 11th
+	s.DailyUsers["2026-02-09"] = 6 // 30 days before it
+
+	day := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	s.rollDay(day)
+	s.DayUsers["a"], s.DayUsers["b"] = true, true
+
+	// Later the same day, and the same UTC day in another zone, change nothing
+	s.rollDay(day.Add(14 * time.Hour))
+	s.rollDay(time.Date(2026, 3, 11, 2, 0, 0, 0, time.FixedZone("UTC+5", 5*3600)))
+	if s.Day != "2026-03-10" || len(s.DayUsers) != 2 {
+		t.Fatalf("day %s with %d users, want 2026-03-10 with 2", s.Day, len(s.DayUsers))
+	}
+	if got := s.ActiveUsers(day); got != 2 {
+		t.Errorf("active users today = %d, want 2", got)
+	}
+
+	next := day.AddDate(0, 0, 1)
+	s.rollDay(next)
+	if s.Day != "2026-03-11" || len(s.DayUsers) != 0 {
+		t.Errorf("day %s with %d users after it ended", s.Day, len(s.DayUsers))
+	}
+	if got := s.ActiveUsers(day); got != 2 {
+		t.Errorf("active users yesterday = %d, want 2", got)
+	}
+	if got := s.ActiveUsers(next); got != 0 {
+		t.Errorf("active users today = %d, want 0", got)
+	}
+	if _, ok := s.DailyUsers["2026-02-08"]; ok {
+		t.Error("a day beyond statsDays was kept")
+	}
+	if got := s.DailyUsers["2026-02-09"]; got != 6 {
+		t.Errorf("the oldest kept day has %d users, want 6", got)
+	}
+}
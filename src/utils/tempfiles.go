@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempPrefixes name the scratch files written next to the downloads, which are removed once sent
+var tempPrefixes = []string{"playlist_", "favorites_", "lyrics_", ".readyz-"}
+
+// isTempFile reports whether name is a partial download or a scratch file rather than a finished track:
+// a download or transcode in progress (.part), the cover of a transcode, an OGG being repaired, or the
+// .encrypted and _decrypted.ogg files left by older versions, which decrypted in two steps
+func isTempFile(name string) bool {
+	switch {
+	case strings.HasSuffix(name, ".part"), strings.Contains(name, ".part."),
+		strings.HasSuffix(name, ".cover.jpg"),
+		strings.Contains(name, ".ogg.") && strings.HasSuffix(name, ".tmp"),
+		strings.HasSuffix(name, ".encrypted"), strings.HasSuffix(name, "_decrypted.ogg"):
+		return true
+	}
+	for _, prefix := range tempPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveTempFiles deletes the partial downloads and scratch files under dir and returns how many
+// it removed. It must only run while no job writes to dir.
+func RemoveTempFiles(dir string) (int, error) {
+	removed := 0
+	var errs []error
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if d.IsDir() || !isTempFile(d.Name()) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		removed++
+		return nil
+	})
+	return removed, errors.Join(append(errs, err)...)
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// StoredTrack is a delivered track, kept by its TC so the buttons below it keep working later.
+// Like the tokens behind other buttons it expires unless the track is delivered again.
+type StoredTrack struct {
+	Name     string `json:"name"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	Platform string `json:"platform"`
+	Year     int    `json:"year"`
+	Duration int    `json:"duration"`
+	Source   string `json:"source"`  // URL or ID the track was fetched with, to download it again
+	FileID   string `json:"file_id"` // latest upload, empty when Telegram didn't tell
+	BotID    int64  `json:"bot_id"`  // bot that uploaded FileID, the only one that can send it
+	Lyrics   string `json:"lyrics"`
+	Expires  int64  `json:"expires"`
+}
+
+// Track returns the track metadata stored with the record
+func (s *StoredTrack) Track(tc string) *TrackInfo {
+	return &TrackInfo{
+		Name:     s.Name,
+		Artist:   s.Artist,
+		Album:    s.Album,
+		Platform: s.Platform,
+		Year:     s.Year,
+		Duration: s.Duration,
+		TC:       tc,
+		Lyrics:   s.Lyrics,
+	}
+}
+
+// GetStoredTrack returns the record of a delivered track
+func GetStoredTrack(tc string) (*StoredTrack, bool) {
+	var stored StoredTrack
+	if ok, err := dbGet(bucketTracks, tc, &stored); err != nil || !ok || time.Now().Unix() >= stored.Expires {
+		return nil, false
+	}
+	return &stored, true
+}
+
+// RememberTrack records a track delivered by the bot botID. Known lyrics, source and file_id are
+// kept when the new delivery lacks them, as a re-send from the file cache does.
+func RememberTrack(botID int64, track *TrackInfo, source, fileID string) error {
+	if track.TC == "" {
+		return nil
+	}
+
+	stored := StoredTrack{
+		Name:     track.Name,
+		Artist:   track.Artist,
+		Album:    track.Album,
+		Platform: track.Platform,
+		Year:     track.Year,
+		Duration: track.Duration,
+		Source:   source,
+		FileID:   fileID,
+		BotID:    botID,
+		Lyrics:   track.Lyrics,
+		Expires:  time.Now().Add(tokenLifetime()).Unix(),
+	}
+	if old, ok := GetStoredTrack(track.TC); ok {
+		if stored.Source == "" {
+			stored.Source = old.Source
+		}
+		if stored.FileID == "" {
+			stored.FileID, stored.BotID = old.FileID, old.BotID
+		}
+		if ParseLyrics(stored.Lyrics).Empty() {
+			stored.Lyrics = old.Lyrics
+		}
+	}
+	return dbPut(bucketTracks, track.TC, stored)
+}
+
+// RecordDelivery remembers a track the bot botID sent to a user and adds it to their history
+func RecordDelivery(userID, botID int64, track *TrackInfo, source, fileID string) {
+	if err := RememberTrack(botID, track, source, fileID); err != nil {
+		log.Printf("[Tracks] ❌ Failed to remember %s: %v", track.TC, err)
+	}
+	AddHistory(userID, botID, track, source, fileID)
+}
+
+// sweepTracks removes the tracks not delivered again within the token lifetime
+func sweepTracks(now time.Time) (int, error) {
+	return dbDeleteWhere(bucketTracks, func(_, v []byte) bool {
+		var stored StoredTrack
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return true
+		}
+		return now.Unix() >= stored.Expires
+	})
+}
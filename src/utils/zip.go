@@ -0,0 +1,380 @@
+package utils
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"songBot/src/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zipEntryOverhead bounds the bytes an entry adds besides its data: local header, data descriptor
+// and central directory record, each with room for the extra fields, plus the end of the archive
+const zipEntryOverhead = 30 + 24 + 46 + 2*64 + 22
+
+// m3uHeader starts every playlist.m3u8
+const m3uHeader = "#EXTM3U\n"
+
+var errTrackTooLarge = errors.New("track is larger than the archive size limit")
+
+// ZipPart is one archive of a playlist; parts are extractable on their own
+type ZipPart struct {
+	Path   string
+	Name   string // file name to send it as, e.g. "Playlist (part 1 of 3).zip"
+	Tracks []int  // playlist positions (1-based) of the tracks it holds
+}
+
+// ZipResult contains information about the ZIP creation process
+type ZipResult struct {
+	Parts        []ZipPart
+	SuccessCount int
+	Errors       []error
+}
+
+// zipItem is the outcome of downloading one track of a playlist
+type zipItem struct {
+	index int
+	track MusicTrack
+	info  *TrackInfo
+	path  string
+	cover []byte
+	err   error
+}
+
+// playlistArchive writes the tracks of a playlist into ZIP files of at most maxSize bytes. Every part
+// ends with an m3u8 playlist of its own tracks and the cover.
+type playlistArchive struct {
+	maxSize int64
+	width   int
+	cover   []byte
+	parts   []ZipPart
+	current *archivePart
+	err     error // a failure of the archive itself, which ends it
+}
+
+// archivePart is the ZIP file being written
+type archivePart struct {
+	file     *os.File
+	zw       *zip.Writer
+	size     int64 // upper bound of the finished file size, without the m3u8 playlist and cover
+	names    map[string]bool
+	playlist strings.Builder
+	tracks   []int
+}
+
+func newPlaylistArchive(trackCount int, maxSize int64) *playlistArchive {
+	return &playlistArchive{
+		maxSize: maxSize,
+		width:   max(2, len(strconv.Itoa(trackCount))),
+	}
+}
+
+func newArchivePart() (*archivePart, error) {
+	file, err := os.CreateTemp(config.Cfg.DownloadPath, "playlist_*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zip file: %w", err)
+	}
+
+	p := &archivePart{file: file, zw: zip.NewWriter(file), names: make(map[string]bool)}
+	p.playlist.WriteString(m3uHeader)
+	return p, nil
+}
+
+// entryName returns "NN - Artist - Title.ext", numbered in playlist order and unique within the part
+func (p *archivePart) entryName(item *zipItem, width int) string {
+	ext := filepath.Ext(item.path)
+	if info, err := ProbeAudio(item.path); err == nil {
+		ext = info.Ext
+	}
+
+	base := SanitizeFilename(fmt.Sprintf("%0*d - %s - %s", width, item.index+1, item.info.Artist, item.info.Name))
+	name := base + ext
+	for n := 2; p.names[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	return name
+}
+
+// fits reports whether an entry of the given size and playlist line keeps a part of partSize bytes and
+// playlistLen bytes of m3u8 within the size limit, counting what closePart adds
+func (a *playlistArchive) fits(partSize int64, playlistLen int, entrySize int64, line string) bool {
+	tail := int64(playlistLen+len(line)+len(a.cover)) + 2*zipEntryOverhead
+	return partSize+entrySize+tail <= a.maxSize
+}
+
+// addTrack streams a downloaded track into the current part, starting a new part first when the track
+// would push it over the size limit. Audio is already compressed, so it is stored as is.
+func (a *playlistArchive) addTrack(item *zipItem) error {
+	if a.err != nil {
+		return a.err
+	}
+
+	src, err := os.Open(item.path)
+	if err != nil {
+		return fmt.Errorf("failed to read downloaded file: %w", err)
+	}
+	defer func(src *os.File) {
+		_ = src.Close()
+	}(src)
+
+	stat, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read downloaded file: %w", err)
+	}
+
+	// The cover is picked before any track is written, so the size checks of every part include it
+	if a.cover == nil && len(a.parts) == 0 && (a.current == nil || len(a.current.tracks) == 0) {
+		a.cover = item.cover
+	}
+	if a.current == nil {
+		if a.current, a.err = newArchivePart(); a.err != nil {
+			return a.err
+		}
+	}
+
+	// A new part has no name collisions, so the name, and the size computed from it, can only shrink there
+	name := a.current.entryName(item, a.width)
+	line := playlistLine(item, name)
+	needed := stat.Size() + int64(2*len(name)) + zipEntryOverhead
+	// A track too large for even an empty part is skipped without closing the current one
+	if !a.fits(0, len(m3uHeader), needed, line) {
+		return fmt.Errorf("%w (%d bytes)", errTrackTooLarge, stat.Size())
+	}
+	if !a.fits(a.current.size, a.current.playlist.Len(), needed, line) {
+		if a.err = a.closePart(); a.err != nil {
+			return a.err
+		}
+		if a.current, a.err = newArchivePart(); a.err != nil {
+			return a.err
+		}
+		name = a.current.entryName(item, a.width)
+		line = playlistLine(item, name)
+	}
+
+	p := a.current
+	w, err := p.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to create zip entry %q: %w", name, err)
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return fmt.Errorf("failed to write %q to zip: %w", name, err)
+	}
+
+	p.names[strings.ToLower(name)] = true
+	p.size += needed
+	p.playlist.WriteString(line)
+	p.tracks = append(p.tracks, item.index+1)
+	return nil
+}
+
+// playlistLine is the m3u8 entry of a track; -1 is the m3u way of saying the duration is unknown
+func playlistLine(item *zipItem, name string) string {
+	duration := item.info.Duration
+	if duration <= 0 {
+		duration = -1
+	}
+	return fmt.Sprintf("#EXTINF:%d,%s - %s\n%s\n", duration, item.info.Artist, item.info.Name, name)
+}
+
+// closePart writes playlist.m3u8 and cover.jpg into the current part and closes it
+func (a *playlistArchive) closePart() error {
+	p := a.current
+	a.current = nil
+
+	files := []struct {
+		name   string
+		data   []byte
+		method uint16
+	}{
+		{"playlist.m3u8", []byte(p.playlist.String()), zip.Deflate},
+		{"cover.jpg", a.cover, zip.Store},
+	}
+	for _, f := range files {
+		if len(f.data) == 0 {
+			continue
+		}
+		w, err := p.zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: f.method, Modified: time.Now()})
+		if err != nil {
+			p.discard()
+			return fmt.Errorf("failed to create zip entry %q: %w", f.name, err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			p.discard()
+			return fmt.Errorf("failed to write %q to zip: %w", f.name, err)
+		}
+	}
+
+	if err := p.zw.Close(); err != nil {
+		p.discard()
+		return fmt.Errorf("failed to close zip writer: %w", err)
+	}
+	if err := p.file.Close(); err != nil {
+		_ = os.Remove(p.file.Name())
+		return fmt.Errorf("failed to close zip file: %w", err)
+	}
+
+	path := p.file.Name()
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	a.parts = append(a.parts, ZipPart{Path: path, Tracks: p.tracks})
+	return nil
+}
+
+// finish closes the last part and names the parts now that their count is known
+func (a *playlistArchive) finish() ([]ZipPart, error) {
+	if a.current != nil && len(a.current.tracks) == 0 {
+		a.current.discard()
+		a.current = nil
+	}
+	if a.err == nil && a.current != nil {
+		a.err = a.closePart()
+	}
+	if a.err != nil {
+		a.discard()
+		return nil, a.err
+	}
+
+	for i := range a.parts {
+		a.parts[i].Name = "Playlist.zip"
+		if len(a.parts) > 1 {
+			a.parts[i].Name = fmt.Sprintf("Playlist (part %d of %d).zip", i+1, len(a.parts))
+		}
+	}
+	return a.parts, nil
+}
+
+// discard deletes every part, finished or not
+func (a *playlistArchive) discard() {
+	if a.current != nil {
+		a.current.discard()
+		a.current = nil
+	}
+	for _, part := range a.parts {
+		_ = os.Remove(part.Path)
+	}
+	a.parts = nil
+}
+
+func (p *archivePart) discard() {
+	_ = p.zw.Close()
+	_ = p.file.Close()
+	_ = os.Remove(p.file.Name())
+}
+
+// downloadZipItem downloads one track of a playlist in the given format
+func downloadZipItem(ctx context.Context, item *zipItem, format AudioFormat) {
+	info, err := Provider().GetTrack(item.track.URL)
+	if err != nil {
+		item.err = fmt.Errorf("track %s: failed to get track info: %w", item.track.ID, err)
+		return
+	}
+
+	dl, err := NewDownload(*info)
+	if err != nil {
+		item.err = fmt.Errorf("track %s: invalid download: %w", item.track.ID, err)
+		return
+	}
+	dl.Format = format
+
+	item.info = info
+	item.path, item.cover, item.err = dl.Process(ctx)
+	if item.err != nil {
+		item.err = fmt.Errorf("track %s: failed to download track: %w", item.track.ID, item.err)
+	}
+}
+
+// ZipTracks creates a ZIP archive containing all tracks from PlatformTracks in the given format;
+// cancelling ctx aborts it.
+// Tracks download concurrently and are streamed into the archive in playlist order as soon as the
+// previous ones are written. The downloaded files are shared with other jobs through the downloads
+// directory and left for the janitor to evict.
+func ZipTracks(ctx context.Context, tracks *PlatformTracks, format AudioFormat) (*ZipResult, error) {
+	if len(tracks.Results) == 0 {
+		return nil, errors.New("no tracks to process")
+	}
+
+	archive := newPlaylistArchive(len(tracks.Results), config.Cfg.ZipPartSize)
+
+	// A slot is held from the start of a download until its file is written, which bounds the
+	// downloads waiting in the reorder buffer; the next track to write always holds one of the slots
+	slots := make(chan struct{}, config.Cfg.ZipConcurrency)
+	done := make(chan *zipItem)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, track := range tracks.Results {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			wg.Add(1)
+			go func(item *zipItem) {
+				defer wg.Done()
+				downloadZipItem(ctx, item, format)
+				done <- item
+			}(&zipItem{index: i, track: track})
+		}
+	}()
+
+	result := &ZipResult{}
+	pending := make(map[int]*zipItem)
+	for next := 0; next < len(tracks.Results); {
+		select {
+		case item := <-done:
+			pending[item.index] = item
+		case <-ctx.Done():
+			// Let the running downloads finish, they block until done is read
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			for range done {
+			}
+			archive.discard()
+			return nil, ctx.Err()
+		}
+
+		for item, ok := pending[next]; ok; item, ok = pending[next] {
+			if item.err == nil {
+				item.err = archive.addTrack(item)
+			}
+			if item.err != nil {
+				result.Errors = append(result.Errors, item.err)
+			} else {
+				result.SuccessCount++
+			}
+
+			delete(pending, next)
+			next++
+			<-slots
+		}
+	}
+
+	parts, err := archive.finish()
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		holdFile(ctx, part.Path)
+	}
+	result.Parts = parts
+
+	if result.SuccessCount == 0 {
+		return result, fmt.Errorf("no tracks were successfully added to the zip: %v", result.Errors)
+	}
+	return result, nil
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"songBot/src/config"
+	"strings"
+	"testing"
+)
+
+// useDownloadDir points the downloads directory, where the parts are written, at a fresh directory
+func useDownloadDir(t *testing.T) {
+	t.Helper()
+	saved := config.Cfg.DownloadPath
+	config.Cfg.DownloadPath = t.TempDir()
+	t.Cleanup(func() { config.Cfg.DownloadPath = saved })
+}
+
+// testItem writes a fake track of size bytes and returns it as the index-th track of a playlist
+func testItem(t *testing.T, index int, size int64) *zipItem {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), fmt.Sprintf("track%d.mp3", index))
+	if err := os.WriteFile(path, bytes.Repeat([]byte{byte(index)}, int(size)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return &zipItem{
+		index: index,
+		info:  &TrackInfo{Name: fmt.Sprintf("Song %d", index+1), Artist: "Artist", Duration: 180},
+		path:  path,
+		cover: []byte("cover art"),
+	}
+}
+
+// readPart returns the entries of a part, checking it stays within maxSize
+func readPart(t *testing.T, part ZipPart, maxSize int64) map[string][]byte {
+	t.Helper()
+	stat, err := os.Stat(part.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() > maxSize {
+		t.Errorf("%s: %d bytes, over the %d byte limit", part.Name, stat.Size(), maxSize)
+	}
+
+	zr, err := zip.OpenReader(part.Path)
+	if err != nil {
+		t.Fatalf("%s: %v", part.Name, err)
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+
+	entries := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("%s/%s: %v", part.Name, f.Name, err)
+		}
+		entries[f.Name] = data
+	}
+	return entries
+}
+
+func TestPlaylistArchiveSplits(t *testing.T) {
+	useDownloadDir(t)
+	const maxSize = 1 << 20
+	a := newPlaylistArchive(5, maxSize)
+	for i := 0; i < 5; i++ {
+		if err := a.addTrack(testItem(t, i, 300<<10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parts, err := a.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Three 300KB tracks fill a 1MB part
+	if len(parts) != 2 || !slices.Equal(parts[0].Tracks, []int{1, 2, 3}) || !slices.Equal(parts[1].Tracks, []int{4, 5}) {
+		t.Fatalf("parts = %+v", parts)
+	}
+	for i, part := range parts {
+		if want := fmt.Sprintf("Playlist (part %d of 2).zip", i+1); part.Name != want {
+			t.Errorf("part %d named %q, want %q", i+1, part.Name, want)
+		}
+
+		// Each part holds its own tracks, a playlist of them and the cover
+		entries := readPart(t, part, maxSize)
+		if string(entries["cover.jpg"]) != "cover art" {
+			t.Errorf("%s: cover %q", part.Name, entries["cover.jpg"])
+		}
+		playlist := string(entries["playlist.m3u8"])
+		if !strings.HasPrefix(playlist, m3uHeader) {
+			t.Errorf("%s: playlist %q", part.Name, playlist)
+		}
+		for _, n := range part.Tracks {
+			name := fmt.Sprintf("%02d - Artist - Song %d.mp3", n, n)
+			if len(entries[name]) != 300<<10 || entries[name][0] != byte(n-1) {
+				t.Errorf("%s: entry %q has %d bytes", part.Name, name, len(entries[name]))
+			}
+			if !strings.Contains(playlist, "#EXTINF:180,Artist - Song "+fmt.Sprint(n)+"\n"+name+"\n") {
+				t.Errorf("%s: %q is not in the playlist:\n%s", part.Name, name, playlist)
+			}
+		}
+		if want := len(part.Tracks) + 2; len(entries) != want {
+			t.Errorf("%s: %d entries, want %d", part.Name, len(entries), want)
+		}
+	}
+}
+
+func TestPlaylistArchiveSinglePart(t *testing.T) {
+	useDownloadDir(t)
+	a := newPlaylistArchive(120, 1<<20)
+	for i := 0; i < 2; i++ {
+		if err := a.addTrack(testItem(t, i, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parts, err := a.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || parts[0].Name != "Playlist.zip" {
+		t.Fatalf("parts = %+v", parts)
+	}
+	// Numbers are as wide as the playlist is long
+	if entries := readPart(t, parts[0], 1<<20); entries["002 - Artist - Song 2.mp3"] == nil {
+		t.Errorf("no 002 entry")
+	}
+}
+
+func TestPlaylistArchiveTrackTooLarge(t *testing.T) {
+	useDownloadDir(t)
+	const maxSize = 1 << 20
+	a := newPlaylistArchive(3, maxSize)
+	if err := a.addTrack(testItem(t, 0, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.addTrack(testItem(t, 1, maxSize)); !errors.Is(err, errTrackTooLarge) {
+		t.Fatalf("err = %v, want errTrackTooLarge", err)
+	}
+	// The skipped track did not close the part
+	if err := a.addTrack(testItem(t, 2, 1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	parts, err := a.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || !slices.Equal(parts[0].Tracks, []int{1, 3}) {
+		t.Fatalf("parts = %+v", parts)
+	}
+}
+
+func TestPlaylistArchiveFits(t *testing.T) {
+	useDownloadDir(t)
+	const maxSize = 1 << 20
+	for _, extra := range []int64{0, 1} {
+		t.Run(fmt.Sprintf("%d byte over", extra), func(t *testing.T) {
+			a := newPlaylistArchive(2, maxSize)
+			if err := a.addTrack(testItem(t, 0, 200<<10)); err != nil {
+				t.Fatal(err)
+			}
+
+			// The largest second track the accounting lets into the part
+			item := testItem(t, 1, 0)
+			name := a.current.entryName(item, a.width)
+			line := playlistLine(item, name)
+			tail := int64(a.current.playlist.Len()+len(line)+len(a.cover)) + 2*zipEntryOverhead
+			size := maxSize - tail - a.current.size - int64(2*len(name)) - zipEntryOverhead + extra
+			needed := size + int64(2*len(name)) + zipEntryOverhead
+			if got := a.fits(a.current.size, a.current.playlist.Len(), needed, line); got != (extra == 0) {
+				t.Fatalf("fits(%d byte track) = %v, want %v", size, got, extra == 0)
+			}
+
+			item = testItem(t, 1, size)
+			if err := a.addTrack(item); err != nil {
+				t.Fatal(err)
+			}
+			parts, err := a.finish()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A track that fits exactly fills the part to at most the limit, one byte more starts a new part
+			if want := 1 + int(extra); len(parts) != want {
+				t.Fatalf("%d parts, want %d", len(parts), want)
+			}
+			for _, part := range parts {
+				readPart(t, part, maxSize)
+			}
+		})
+	}
+}